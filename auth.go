@@ -0,0 +1,123 @@
+/*
+ * COPYRIGHT 2020 Brightgate Inc.  All rights reserved.
+ *
+ * This copyright notice is Copyright Management Information under 17 USC 1202
+ * and is included to protect this work and deter copyright infringement.
+ * Removal or alteration of this Copyright Management Information without the
+ * express written permission of Brightgate Inc is prohibited, and any
+ * such unauthorized removal or alteration will be a violation of federal law.
+ */
+
+package briefpg
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// AuthMethod selects how clients must authenticate to the server.  See the
+// Postgres documentation for pg_hba.conf for details.
+type AuthMethod string
+
+const (
+	// AuthTrust allows any client to connect without a password.  This is
+	// the default.
+	AuthTrust AuthMethod = "trust"
+	// AuthPassword requires a password, sent in the clear.
+	AuthPassword AuthMethod = "password"
+	// AuthMD5 requires a password, using MD5 challenge-response.
+	AuthMD5 AuthMethod = "md5"
+	// AuthSCRAM requires a password, using SCRAM-SHA-256.
+	AuthSCRAM AuthMethod = "scram-sha-256"
+)
+
+// hbaTemplate is the pg_hba.conf written when password authentication is in
+// use.  It covers the Unix-domain socket and the loopback addresses.
+const hbaTemplate = `# Generated by briefpg
+local   all   all                  %[1]s
+host    all   all   127.0.0.1/32   %[1]s
+host    all   all   ::1/128        %[1]s
+`
+
+func (bp *BriefPG) setAuth(method AuthMethod, password string) error {
+	if bp.state >= stateInitialized {
+		return fmt.Errorf("auth method cannot be set after db has been initialized")
+	}
+	switch method {
+	case AuthTrust:
+		bp.authMethod = method
+		bp.password = ""
+		return nil
+	case AuthPassword, AuthMD5, AuthSCRAM:
+	default:
+		return fmt.Errorf("Invalid auth method %q", method)
+	}
+
+	if password == "" {
+		b := make([]byte, 16)
+		if _, err := rand.Read(b); err != nil {
+			return fmt.Errorf("Failed to generate password: %w", err)
+		}
+		password = hex.EncodeToString(b)
+	}
+	bp.authMethod = method
+	bp.password = password
+	return nil
+}
+
+// Password returns the superuser's password, or "" if password
+// authentication is not in use.
+func (bp *BriefPG) Password() string {
+	return bp.password
+}
+
+// passwordEncryption returns the password_encryption setting which suits the
+// authentication method.  An MD5 hash can't be used for SCRAM authentication,
+// but a SCRAM verifier can be used for the other methods.
+func (bp *BriefPG) passwordEncryption() string {
+	if bp.authMethod == AuthMD5 || !bp.pgVer.SupportsSCRAM() {
+		return "md5"
+	}
+	return "scram-sha-256"
+}
+
+// setupAuth configures password authentication in a newly initialized
+// cluster: it sets the superuser's password, and replaces pg_hba.conf.  The
+// cluster is always created with trust authentication, so that it can be
+// cached (see OptInitDBCache); the password is set using the server's
+// single-user mode, before the server is started for the first time.
+func (bp *BriefPG) setupAuth(ctx context.Context) error {
+	if bp.authMethod == AuthTrust {
+		return nil
+	}
+
+	postgres := filepath.Join(filepath.Dir(bp.pgCmds["pg_ctl"]), "postgres")
+	cmd := exec.CommandContext(ctx, postgres, "--single", "-D", bp.DbDir(),
+		"-c", "lc_messages=C", "-c", "password_encryption="+bp.passwordEncryption(),
+		"postgres")
+	cmd.Stdin = strings.NewReader(fmt.Sprintf("ALTER ROLE %s PASSWORD %s\n",
+		quoteIdent(superUser), quoteLiteral(bp.password)))
+	bp.logf("briefpg: %s\n", strings.Join(cmd.Args, " "))
+	cmdOut, err := cmd.CombinedOutput()
+	if err == nil && strings.Contains(string(cmdOut), "ERROR:") {
+		err = fmt.Errorf("ALTER ROLE failed")
+	}
+	if err != nil {
+		bp.logf("briefpg: FAILED: %s\n", string(cmdOut))
+		return wrapOutputErr(ctx, "Setting password failed", cmd, string(cmdOut), err)
+	}
+
+	hbaFile := filepath.Join(bp.DbDir(), "pg_hba.conf")
+	bp.logf("briefpg: generating %s\n", hbaFile)
+	hba := fmt.Sprintf(hbaTemplate, bp.authMethod)
+	if err := ioutil.WriteFile(hbaFile, []byte(hba), 0600); err != nil {
+		return fmt.Errorf("Failed to write pg_hba.conf: %w", err)
+	}
+	return nil
+}
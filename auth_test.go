@@ -0,0 +1,85 @@
+/*
+ * COPYRIGHT 2020 Brightgate Inc.  All rights reserved.
+ *
+ * This copyright notice is Copyright Management Information under 17 USC 1202
+ * and is included to protect this work and deter copyright infringement.
+ * Removal or alteration of this Copyright Management Information without the
+ * express written permission of Brightgate Inc is prohibited, and any
+ * such unauthorized removal or alteration will be a violation of federal law.
+ */
+
+package briefpg
+
+import (
+	"context"
+	"io/ioutil"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestAuth(t *testing.T) {
+	ctx := context.Background()
+	for _, method := range []AuthMethod{AuthPassword, AuthMD5, AuthSCRAM} {
+		for _, tcp := range []bool{false, true} {
+			options := []Option{OptLogFunc(t.Logf), OptAuth(method, "")}
+			if tcp {
+				options = append(options, OptListenTCP())
+			}
+			bpg, err := New(options...)
+			if err != nil {
+				t.Fatalf("New failed: %v", err)
+			}
+			if bpg.Password() == "" {
+				t.Fatalf("Expected a generated password")
+			}
+			if err = bpg.Start(ctx); err != nil {
+				t.Fatalf("Start(%s) failed: %v", method, err)
+			}
+			if !strings.Contains(bpg.DBUri("test_db"), bpg.Password()) {
+				t.Fatalf("Expected password in URI: %s", bpg.DBUri("test_db"))
+			}
+			if _, err = bpg.CreateDB(ctx, "test_db", ""); err != nil {
+				t.Fatalf("CreateDB(%s) failed: %v", method, err)
+			}
+			if err = bpg.DumpDB(ctx, "test_db", ioutil.Discard); err != nil {
+				t.Fatalf("DumpDB(%s) failed: %v", method, err)
+			}
+
+			for _, pw := range []string{"wrong", ""} {
+				cmd := exec.Command(bpg.pgCmds["psql"], "-w", "-c", "SELECT 1",
+					bpg.uri("test_db", superUser, pw))
+				out, err := cmd.CombinedOutput()
+				if err == nil {
+					t.Fatalf("Expected %s auth with %q to fail: %s", method, pw, out)
+				}
+			}
+			bpg.MustFini(ctx)
+		}
+	}
+}
+
+func TestAuthOptions(t *testing.T) {
+	ctx := context.Background()
+	bpg, err := New(OptLogFunc(t.Logf), OptAuth(AuthSCRAM, "it's a secret&"))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer bpg.MustFini(ctx)
+	if bpg.Password() != "it's a secret&" {
+		t.Fatalf("Unexpected password: %q", bpg.Password())
+	}
+	if err = bpg.SetOption(OptAuth(AuthMethod("bogus"), "")); err == nil {
+		t.Fatalf("Expected SetOption to fail")
+	}
+
+	if err = bpg.Start(ctx); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	if _, err = bpg.CreateDB(ctx, "test_db", ""); err != nil {
+		t.Fatalf("CreateDB failed: %v", err)
+	}
+	if err = bpg.SetOption(OptAuth(AuthTrust, "")); err == nil {
+		t.Fatalf("Expected SetOption to fail")
+	}
+}
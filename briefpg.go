@@ -23,15 +23,22 @@ package briefpg
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net"
+	"net/url"
 	"os"
 	"os/exec"
 	"os/user"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"text/template"
+	"time"
 )
 
 const (
@@ -51,8 +58,35 @@ log_connections = on
 log_disconnections = on
 max_worker_processes = 4
 `
+
+	// superUser is the name of the database superuser created by initdb.
+	superUser = "postgres"
+
+	// tcpListenAddr is the address the server binds when OptListenTCP is
+	// in effect.
+	tcpListenAddr = "127.0.0.1"
+
+	// maxListenAttempts bounds the number of times Start will pick a new
+	// port if another process grabs the one we chose.
+	maxListenAttempts = 5
+
+	// maxCloneAttempts bounds the number of times CloneDB will terminate
+	// connections to a busy template and try again.
+	maxCloneAttempts = 3
+
+	// abortTimeout bounds the time spent cleaning up after an interrupted
+	// Start.
+	abortTimeout = 30 * time.Second
 )
 
+// settingNameRE matches the names of Postgres configuration parameters,
+// including those of extensions (which are qualified by the extension name).
+var settingNameRE = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+// ErrPostgresNotFound is returned (wrapped) when a usable installation of
+// PostgreSQL cannot be located.
+var ErrPostgresNotFound = errors.New("couldn't find Postgres")
+
 type bpState int
 
 const (
@@ -64,6 +98,20 @@ const (
 	stateDefunct
 )
 
+// StopMode selects how Stop() shuts down the server.  See the documentation
+// for pg_ctl's -m option for details.
+type StopMode string
+
+const (
+	// StopSmart waits for all clients to disconnect before shutting down.
+	StopSmart StopMode = "smart"
+	// StopFast disconnects clients and then shuts down cleanly.
+	StopFast StopMode = "fast"
+	// StopImmediate kills the server without a clean shutdown, as if it
+	// had crashed; it performs crash recovery when next started.
+	StopImmediate StopMode = "immediate"
+)
+
 // LogFunction describes a basic printf-style function.
 type LogFunction func(string, ...interface{})
 
@@ -74,6 +122,9 @@ func NullLogFunction(format string, a ...interface{}) {
 
 type cmdMap map[string]string
 
+// confMap holds configuration parameter settings, keyed by name.
+type confMap map[string]string
+
 // BriefPG represents a managed instance of the Postgres database server; the
 // instance and all associated data is disposed when Fini() is called.
 type BriefPG struct {
@@ -82,13 +133,31 @@ type BriefPG struct {
 	encoding       string      // Defaults to "UNICODE", set with OptPostgresEncoding
 	pgConfTemplate string      // Postgres Config File template, set with OptPgConfTemplate
 	logf           LogFunction // Verbose output, set with OptLogFunc
+	listenTCP      bool        // Listen on 127.0.0.1, set with OptListenTCP
+	port           int         // TCP port, assigned when the server starts
+	initDBCache    string      // initdb cache directory, set with OptInitDBCache
+	useWatchdog    bool        // Set with OptWatchdog
+	settings       confMap     // postgresql.conf overrides, set with OptSetting
+	authMethod     AuthMethod  // Defaults to AuthTrust, set with OptAuth
+	password       string      // Superuser password, set with OptAuth
+	useTLS         bool        // Set with OptTLS
+	tls            *tlsState   // Generated certificates, when useTLS is set
+	extensions     []string    // Created by CreateDB, set with OptExtensions
+	rolePasswords  confMap     // Passwords of roles made by CreateRole
+	watchdog       *watchdog   // Running watchdog process, if any
+	pool           *dbPool     // Database pool, if started by StartPool
+	shared         *shareState // Set by OptShared
 	state          bpState
 	pgCmds         cmdMap
-	pgVer          string // Detected Postgres version corresponding to pgCmds
+	pgVer          Version // Detected Postgres version corresponding to pgCmds
 }
 
 var utilities = []string{"psql", "initdb", "pg_ctl", "pg_dump"}
 
+// optionalUtilities are used if present alongside the utilities, but
+// operations which need them fail if not.
+var optionalUtilities = []string{"pg_restore"}
+
 var tryGlobs = []string{
 	"/usr/lib/postgresql/*/bin", // Debian
 	"/usr/pgsql-*/bin",          // Centos
@@ -97,8 +166,13 @@ var tryGlobs = []string{
 	"/usr/local/bin", // MacOS Homebrew, and others
 }
 
-func wrapExecErr(msg string, cmd *exec.Cmd, err error) error {
+// wrapExecErr describes the failure of cmd.  If the failure was caused by the
+// cancellation or expiry of ctx, the error says so, and wraps ctx.Err().
+func wrapExecErr(ctx context.Context, msg string, cmd *exec.Cmd, err error) error {
 	args := strings.Join(cmd.Args, " ")
+	if cerr := ctx.Err(); cerr != nil {
+		return fmt.Errorf("%s; interrupted: command: %s: %w", msg, args, cerr)
+	}
 	if xerr, ok := err.(*exec.ExitError); ok {
 		return fmt.Errorf("%s; command: %s; stderr: %s: %w",
 			msg, args, xerr.Stderr, xerr)
@@ -106,12 +180,77 @@ func wrapExecErr(msg string, cmd *exec.Cmd, err error) error {
 	return fmt.Errorf("%s", msg)
 }
 
-// findPostgres will look for a valid Postgres instance in path.  If path is
-// "", then it will search the user's $PATH for a valid instance.  If that
-// fails, it will search a set of well-known postgres directories.
-func findPostgres(path string) (cmdMap, error) {
+// wrapOutputErr is like wrapExecErr, but for commands whose combined output
+// was collected; the output is included in the error.
+func wrapOutputErr(ctx context.Context, msg string, cmd *exec.Cmd, out string, err error) error {
+	args := strings.Join(cmd.Args, " ")
+	if cerr := ctx.Err(); cerr != nil {
+		return fmt.Errorf("%s; interrupted: command: %s: %w", msg, args, cerr)
+	}
+	return fmt.Errorf("%s; command: %s; output: %s: %w", msg, args, out, err)
+}
+
+// quoteIdent quotes s for use as an SQL identifier.
+func quoteIdent(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
+
+// quoteConfValue quotes s for use as a value in postgresql.conf.  Any value
+// may be quoted, including numbers and booleans.
+func quoteConfValue(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// quoteLiteral quotes s for use as an SQL string literal.
+func quoteLiteral(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// freePort asks the kernel for an unused TCP port on the loopback interface.
+// Nothing prevents another process from claiming the port before postgres
+// binds it, so callers must be prepared to retry.
+func freePort() (int, error) {
+	l, err := net.Listen("tcp", net.JoinHostPort(tcpListenAddr, "0"))
+	if err != nil {
+		return 0, fmt.Errorf("Failed to find a free port: %w", err)
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
+
+// fileSize returns the size of the named file, or 0 if it can't be stat'd.
+func fileSize(name string) int64 {
+	fi, err := os.Stat(name)
+	if err != nil {
+		return 0
+	}
+	return fi.Size()
+}
+
+// portInUse examines the server log, starting at offset, to determine whether
+// the server failed to start because its TCP port was already taken.
+func portInUse(logFile string, offset int64) bool {
+	f, err := os.Open(logFile)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return false
+	}
+	logb, err := ioutil.ReadAll(f)
+	if err != nil {
+		return false
+	}
+	return strings.Contains(string(logb), "Address already in use")
+}
+
+// searchPath returns the directories in which to look for Postgres.  If path
+// is "", this is the user's $PATH followed by a set of well-known postgres
+// directories; otherwise it is just path.
+func searchPath(path string) []string {
 	var allPaths = make([]string, 0)
-	var pgCmds = make(cmdMap)
 
 	if path != "" {
 		allPaths = append(allPaths, path)
@@ -127,33 +266,57 @@ func findPostgres(path string) (cmdMap, error) {
 			}
 		}
 	}
+	return allPaths
+}
 
-	// For each path element, see if ALL of the commands in utilities
-	// (psql, initdb, ...) are present on that path element.
-	// If yes, that's the version we'll use.
-pathLoop:
-	for _, path := range allPaths {
-		newCmds := make(map[string]string)
-		for _, cName := range utilities {
-			p := filepath.Join(path, cName)
-			if _, err := os.Stat(p); err != nil {
-				continue pathLoop
-			}
-			newCmds[cName] = p
+// pgCmdsIn returns the paths of the commands in utilities (psql, initdb, ...)
+// within dir, or nil if ALL of them are not present there.
+func pgCmdsIn(dir string) cmdMap {
+	pgCmds := make(cmdMap)
+	for _, cName := range utilities {
+		p := filepath.Join(dir, cName)
+		if _, err := os.Stat(p); err != nil {
+			return nil
+		}
+		pgCmds[cName] = p
+	}
+	for _, cName := range optionalUtilities {
+		p := filepath.Join(dir, cName)
+		if _, err := os.Stat(p); err == nil {
+			pgCmds[cName] = p
 		}
-		pgCmds = newCmds
-		break
 	}
+	return pgCmds
+}
 
-	if len(pgCmds) == 0 {
-		return nil, fmt.Errorf("couldn't find Postgres; tried %s",
-			strings.Join(allPaths, ":"))
+// findPostgres will look for a valid Postgres instance in path.  If path is
+// "", then it will search the user's $PATH for a valid instance.  If that
+// fails, it will search a set of well-known postgres directories.
+func findPostgres(path string) (cmdMap, error) {
+	allPaths := searchPath(path)
+
+	// For each path element, see if all of the utilities are present.  If
+	// yes, that's the version we'll use.
+	for _, dir := range allPaths {
+		if pgCmds := pgCmdsIn(dir); pgCmds != nil {
+			return pgCmds, nil
+		}
 	}
-	return pgCmds, nil
+	return nil, fmt.Errorf("%w; tried %s", ErrPostgresNotFound,
+		strings.Join(allPaths, ":"))
+}
+
+// pgCtlVersion runs pg_ctl -V to determine the version of Postgres.
+func pgCtlVersion(pgCtl string) (Version, error) {
+	outb, err := exec.Command(pgCtl, "-V").Output()
+	if err != nil {
+		return Version{}, fmt.Errorf("Failed running pg_ctl -V: %w", err)
+	}
+	return ParseVersion(strings.TrimSpace(string(outb)))
 }
 
 // PostgresInstalled returns an error if the module is unable to operate due to
-// a failure to locate PostgreSQL.
+// a failure to locate PostgreSQL.  The error wraps ErrPostgresNotFound.
 func PostgresInstalled(path string) error {
 	_, err := findPostgres(path)
 	return err
@@ -168,6 +331,7 @@ func New(options ...Option) (*BriefPG, error) {
 	bpg := &BriefPG{
 		state:          stateUninitialized,
 		encoding:       "UNICODE",
+		authMethod:     AuthTrust,
 		logf:           NullLogFunction,
 		pgCmds:         nil,
 		pgConfTemplate: DefaultPgConfTemplate,
@@ -185,7 +349,7 @@ func New(options ...Option) (*BriefPG, error) {
 	if bpg.pgCmds == nil {
 		err := bpg.setPostgresPath("")
 		if err != nil {
-			return nil, fmt.Errorf("Unable to find Postgres")
+			return nil, fmt.Errorf("Unable to find Postgres: %w", err)
 		}
 	}
 
@@ -211,15 +375,8 @@ func (bp *BriefPG) setPostgresPath(pgPath string) error {
 	if err != nil {
 		return err
 	}
-
-	outb, err := exec.Command(bp.pgCmds["pg_ctl"], "-V").Output()
-	if err != nil {
-		return fmt.Errorf("Failed running pg_ctl -V: %w", err)
-	}
-	out := strings.TrimSpace(string(outb))
-	sl := strings.Split(out, " ")
-	bp.pgVer = sl[len(sl)-1]
-	return nil
+	bp.pgVer, err = pgCtlVersion(bp.pgCmds["pg_ctl"])
+	return err
 }
 
 func (bp *BriefPG) setTmpDir(tmpDir string) error {
@@ -230,6 +387,64 @@ func (bp *BriefPG) setTmpDir(tmpDir string) error {
 	return nil
 }
 
+func (bp *BriefPG) setListenTCP() error {
+	if bp.state >= stateServerStarted {
+		return fmt.Errorf("TCP listener cannot be enabled after server has started")
+	}
+	bp.listenTCP = true
+	return nil
+}
+
+func (bp *BriefPG) setInitDBCache(dir string) error {
+	if bp.state >= stateInitialized {
+		return fmt.Errorf("initdb cache cannot be set after db has been initialized")
+	}
+	if dir == "" {
+		cacheDir, err := os.UserCacheDir()
+		if err != nil {
+			return fmt.Errorf("Failed to locate user cache dir: %w", err)
+		}
+		dir = filepath.Join(cacheDir, "briefpg", "initdb")
+	}
+	bp.initDBCache = dir
+	return nil
+}
+
+// setSettings records configuration overrides.  If the cluster has already
+// been initialized, the configuration file is rewritten at once, so that the
+// new settings are picked up by Reload() or Restart().
+// reservedSettings are passed to the server on its command line, which takes
+// precedence over postgresql.conf, so they can't be set with OptSetting.
+var reservedSettings = map[string]string{
+	"listen_addresses": "use OptListenTCP",
+	"port":             "the port is chosen by briefpg",
+	"lc_messages":      "briefpg relies on English server messages",
+}
+
+func (bp *BriefPG) setSettings(settings map[string]string) error {
+	if bp.state == stateDefunct {
+		return fmt.Errorf("settings cannot be changed after Fini")
+	}
+	for name := range settings {
+		if !settingNameRE.MatchString(name) {
+			return fmt.Errorf("Invalid setting name %q", name)
+		}
+		if why, ok := reservedSettings[strings.ToLower(name)]; ok {
+			return fmt.Errorf("Setting %q cannot be changed: %s", name, why)
+		}
+	}
+	if bp.settings == nil {
+		bp.settings = make(confMap)
+	}
+	for name, value := range settings {
+		bp.settings[name] = value
+	}
+	if bp.state >= stateInitialized {
+		return bp.writeConf()
+	}
+	return nil
+}
+
 func (bp *BriefPG) setPostgresEncoding(enc string) error {
 	bp.encoding = enc
 	return nil
@@ -250,19 +465,64 @@ func (bp *BriefPG) mkTemp() error {
 		return fmt.Errorf("Failed to make tmpdir: %w", err)
 	}
 	bp.madeTmpDir = true
+
+	// Record our PID, so that Reap can tell if we've gone away.
+	ownerPID := []byte(strconv.Itoa(os.Getpid()))
+	err = ioutil.WriteFile(filepath.Join(bp.tmpDir, ownerFile), ownerPID, 0600)
+	if err != nil {
+		return fmt.Errorf("Failed to write owner file: %w", err)
+	}
 	return nil
 }
 
-// PgVer returns the detected version of Postgres
+// PgVer returns the detected version of Postgres, as a string such as "16.2".
+// See Version() for a structured form.
 func (bp *BriefPG) PgVer() string {
+	return bp.pgVer.String()
+}
+
+// Version returns the detected version of Postgres.
+func (bp *BriefPG) Version() Version {
 	return bp.pgVer
 }
 
+// Port returns the TCP port the server is listening on, or 0 if the server
+// is not listening on TCP (see OptListenTCP).  The port is assigned by Start().
+func (bp *BriefPG) Port() int {
+	if !bp.listenTCP {
+		return 0
+	}
+	return bp.port
+}
+
 // DbDir returns the installation directory of the Postgres database.  In
 // general, this should not be needed when writing tests, but it is provided
 // for completeness.
 func (bp *BriefPG) DbDir() string {
-	return filepath.Join(bp.tmpDir, bp.pgVer)
+	return filepath.Join(bp.tmpDir, bp.PgVer())
+}
+
+// initDBArgs returns the arguments passed to initdb, other than the data
+// directory.
+func (bp *BriefPG) initDBArgs() []string {
+	return []string{"--nosync", "-U", superUser, "-E", bp.encoding, "-A", "trust"}
+}
+
+// runInitDB runs initdb to create a new cluster in dataDir.
+func (bp *BriefPG) runInitDB(ctx context.Context, dataDir string) error {
+	args := append([]string{"-D", dataDir}, bp.initDBArgs()...)
+	cmd := exec.CommandContext(ctx, bp.pgCmds["initdb"], args...)
+	bp.logf("briefpg: %s\n", strings.Join(cmd.Args, " "))
+	cmdOut, err := cmd.CombinedOutput()
+	if err != nil {
+		bp.logf("briefpg: FAILED: %s\n", string(cmdOut))
+		if ctx.Err() != nil {
+			// initdb was killed, so it didn't clean up after itself.
+			os.RemoveAll(dataDir)
+		}
+		return wrapExecErr(ctx, "initDB failed", cmd, err)
+	}
+	return nil
 }
 
 func (bp *BriefPG) initDB(ctx context.Context) error {
@@ -277,24 +537,44 @@ func (bp *BriefPG) initDB(ctx context.Context) error {
 	}
 
 	if _, err := os.Stat(bp.DbDir()); err != nil {
-		cmd := exec.Command(bp.pgCmds["initdb"], "--nosync", "-U", "postgres",
-			"-D", bp.DbDir(), "-E", bp.encoding, "-A", "trust")
-		bp.logf("briefpg: %s\n", strings.Join(cmd.Args, " "))
-		cmdOut, err := cmd.CombinedOutput()
+		if bp.initDBCache != "" {
+			err = bp.initDBFromCache(ctx)
+		} else {
+			err = bp.runInitDB(ctx, bp.DbDir())
+		}
 		if err != nil {
-			bp.logf("briefpg: FAILED: %s\n", string(cmdOut))
-			return wrapExecErr("initDB failed", cmd, err)
+			return err
+		}
+	}
+	if bp.useTLS {
+		if err := bp.generateTLS(true); err != nil {
+			return fmt.Errorf("initDB failed: %w", err)
 		}
 	}
+	if err := bp.writeConf(); err != nil {
+		return fmt.Errorf("initDB failed: %w", err)
+	}
+	if err := bp.setupAuth(ctx); err != nil {
+		return err
+	}
+	bp.state = stateInitialized
+	return nil
+}
+
+// writeConf generates postgresql.conf from the config template, followed by
+// settings required by other options (such as OptTLS) and any settings given
+// with OptSetting.  As later entries in the file override earlier ones, the
+// settings take precedence over the template.
+func (bp *BriefPG) writeConf() error {
 	confFile := filepath.Join(bp.DbDir(), "postgresql.conf")
 	bp.logf("briefpg: generating %s\n", confFile)
 	tmpl, err := template.New("postgresql.conf").Parse(bp.pgConfTemplate)
 	if err != nil {
-		return fmt.Errorf("initDB failed to parse postgresql.conf template: %w", err)
+		return fmt.Errorf("failed to parse postgresql.conf template: %w", err)
 	}
 	conf, err := os.OpenFile(confFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
 	if err != nil {
-		return fmt.Errorf("initDB failed to open config: %w", err)
+		return fmt.Errorf("failed to open config: %w", err)
 	}
 	defer conf.Close()
 
@@ -305,18 +585,59 @@ func (bp *BriefPG) initDB(ctx context.Context) error {
 	}
 	err = tmpl.Execute(conf, bpConf)
 	if err != nil {
-		return fmt.Errorf("initDB failed to execute template: %w", err)
+		return fmt.Errorf("failed to execute template: %w", err)
+	}
+
+	settings := bp.tlsSettings()
+	for name, value := range bp.settings {
+		settings[name] = value
+	}
+	if len(settings) > 0 {
+		names := make([]string, 0, len(settings))
+		for name := range settings {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		fmt.Fprintf(conf, "\n# Settings from briefpg options\n")
+		for _, name := range names {
+			fmt.Fprintf(conf, "%s = %s\n", name, quoteConfValue(settings[name]))
+		}
+	}
+	if err := conf.Close(); err != nil {
+		return fmt.Errorf("failed to write config: %w", err)
 	}
-	bp.state = stateInitialized
 	return nil
 }
 
-// Start the postgres server, performing necessary initialization along the way
+// messagesOpt makes the server report errors in English, whatever the
+// locale, because we recognize some of them by their text, such as "Address
+// already in use" in the log (see portInUse).
+const messagesOpt = "-c lc_messages=C"
+
+// listenOpts returns the server options which control the listening socket.
+func (bp *BriefPG) listenOpts() string {
+	if !bp.listenTCP {
+		return "-c listen_addresses=''"
+	}
+	return fmt.Sprintf("-c listen_addresses='%s' -c port=%d", tcpListenAddr, bp.port)
+}
+
+// Start the postgres server, performing necessary initialization along the way.
+// Like the other methods which run Postgres commands, Start kills the command
+// if ctx is cancelled or expires, and returns an error which names the
+// interrupted stage and wraps ctx.Err().  A server which was interrupted while
+// starting up is stopped.
 func (bp *BriefPG) Start(ctx context.Context) error {
 	var err error
 	if bp.state == stateDefunct {
 		return fmt.Errorf("briefpg instance is defunct")
 	}
+	if bp.state == stateServerStarted {
+		return fmt.Errorf("Server already started")
+	}
+	if bp.shared != nil {
+		return bp.startShared(ctx)
+	}
 
 	if bp.state < stateInitialized {
 		err = bp.initDB(ctx)
@@ -324,86 +645,359 @@ func (bp *BriefPG) Start(ctx context.Context) error {
 			return err
 		}
 	}
+	if err = bp.startServer(ctx); err != nil {
+		return err
+	}
+	// Without its watchdog, the server could outlive us; don't leave it
+	// running.
+	if err = bp.startWatchdog(); err != nil {
+		bp.abortStart()
+		return err
+	}
+	bp.state = stateServerStarted
+	return nil
+}
 
-	userOpts := "" // XXX
-	postgresOpts := fmt.Sprintf("-c listen_addresses='' %s", userOpts)
+// startServer starts the server in the initialized cluster.
+func (bp *BriefPG) startServer(ctx context.Context) error {
+	var err error
 	logFile := filepath.Join(bp.DbDir(), "postgres.log")
-	cmd := exec.Command(bp.pgCmds["pg_ctl"], "-w", "-o", postgresOpts, "-s",
-		"-D", bp.DbDir(), "-l", logFile, "start")
-	bp.logf("briefpg: %s\n", strings.Join(cmd.Args, " "))
-	cmdOut, err := cmd.CombinedOutput()
-	if err != nil {
+	for attempt := 1; ; attempt++ {
+		if bp.listenTCP && (bp.port == 0 || attempt > 1) {
+			if bp.port, err = freePort(); err != nil {
+				return err
+			}
+		}
+		logOffset := fileSize(logFile)
+
+		cmd := exec.CommandContext(ctx, bp.pgCmds["pg_ctl"], "-w",
+			"-o", bp.listenOpts()+" "+messagesOpt, "-s",
+			"-D", bp.DbDir(), "-l", logFile, "start")
+		bp.logf("briefpg: %s\n", strings.Join(cmd.Args, " "))
+		cmdOut, err := cmd.CombinedOutput()
+		if err == nil {
+			break
+		}
 		bp.logf("briefpg: %s\n", string(cmdOut))
-		return wrapExecErr("Start failed", cmd, err)
+		if bp.listenTCP && attempt < maxListenAttempts && portInUse(logFile, logOffset) {
+			bp.logf("briefpg: port %d already in use; retrying\n", bp.port)
+			continue
+		}
+		if ctx.Err() != nil {
+			bp.abortStart()
+		}
+		return wrapExecErr(ctx, "Start failed", cmd, err)
 	}
-	bp.state = stateServerStarted
 	return nil
 }
 
 // CreateDB is a convenience function to create a named database; you can do
 // this using your database driver instead, at lower cost.  This routine uses
 // 'psql' to do the job.  The primary use case is to rapidly set up an empty
-// database for test purposes.  The URI to access the database is returned.
+// database for test purposes.  Any extensions given with OptExtensions are
+// created in the new database.  The URI to access the database is returned.
 func (bp *BriefPG) CreateDB(ctx context.Context, dbName, createArgs string) (string, error) {
 	if bp.state < stateServerStarted {
 		return "", fmt.Errorf("Server not started; cannot create database")
 	}
-	scmd := fmt.Sprintf("CREATE DATABASE \"%s\" %s", dbName, createArgs)
-	cmd := exec.Command(bp.pgCmds["psql"], "-c", scmd, bp.DBUri("postgres"))
+	if err := bp.checkExtensions(ctx); err != nil {
+		return "", err
+	}
+	scmd := fmt.Sprintf("CREATE DATABASE %s %s", quoteIdent(dbName), createArgs)
+	if _, err := bp.psql(ctx, "CreateDB", "postgres", scmd); err != nil {
+		return "", err
+	}
+	if err := bp.createExtensions(ctx, dbName); err != nil {
+		// Don't leave a half-made database behind.
+		bp.DropDB(ctx, dbName, true)
+		return "", err
+	}
+	return bp.DBUri(dbName), nil
+}
+
+// MakeTemplate marks the named database as a template, so that it can be used
+// as the source for CloneDB().  A typical pattern is to create a database,
+// apply schema migrations to it, mark it as a template, and then clone a
+// fresh copy for each test.
+func (bp *BriefPG) MakeTemplate(ctx context.Context, dbName string) error {
+	if bp.state < stateServerStarted {
+		return fmt.Errorf("Server not started; cannot make template")
+	}
+	scmd := fmt.Sprintf("ALTER DATABASE %s IS_TEMPLATE true", quoteIdent(dbName))
+	_, err := bp.psql(ctx, "MakeTemplate", "postgres", scmd)
+	return err
+}
+
+// CloneDB creates the database newName as a copy of templateName, using
+// CREATE DATABASE ... TEMPLATE.  This is usually much faster than creating a
+// database and repopulating it.  Postgres refuses to copy a database which
+// has active connections, so any connections to the template are terminated
+// first if needed.  The URI to access the new database is returned.
+func (bp *BriefPG) CloneDB(ctx context.Context, templateName, newName string) (string, error) {
+	if bp.state < stateServerStarted {
+		return "", fmt.Errorf("Server not started; cannot clone database")
+	}
+	scmd := fmt.Sprintf("CREATE DATABASE %s TEMPLATE %s",
+		quoteIdent(newName), quoteIdent(templateName))
+	for attempt := 1; ; attempt++ {
+		out, err := bp.psql(ctx, "CloneDB", "postgres", scmd)
+		if err == nil {
+			break
+		}
+		// The server's messages are in English; see messagesOpt.
+		if attempt >= maxCloneAttempts ||
+			!strings.Contains(out, "is being accessed by other users") {
+			return "", err
+		}
+		if err := bp.terminateConnections(ctx, templateName); err != nil {
+			return "", err
+		}
+	}
+	return bp.DBUri(newName), nil
+}
+
+// DropDB drops the named database; dropping a database which doesn't exist is
+// not an error.  Postgres refuses to drop a database which has active
+// connections.  If force is set, those connections are terminated: using DROP
+// DATABASE ... WITH (FORCE) where the server supports it, and otherwise by
+// disconnecting the sessions first.
+func (bp *BriefPG) DropDB(ctx context.Context, dbName string, force bool) error {
+	if bp.state < stateServerStarted {
+		return fmt.Errorf("Server not started; cannot drop database")
+	}
+	scmd := fmt.Sprintf("DROP DATABASE IF EXISTS %s", quoteIdent(dbName))
+	if force && bp.pgVer.SupportsDropForce() {
+		scmd += " WITH (FORCE)"
+		_, err := bp.psql(ctx, "DropDB", "postgres", scmd)
+		return err
+	}
+	for attempt := 1; ; attempt++ {
+		if force {
+			if err := bp.terminateConnections(ctx, dbName); err != nil {
+				return err
+			}
+		}
+		out, err := bp.psql(ctx, "DropDB", "postgres", scmd)
+		if err == nil {
+			return nil
+		}
+		// Clients may reconnect between the two steps.  The server's
+		// messages are in English; see messagesOpt.
+		if !force || attempt >= maxCloneAttempts ||
+			!strings.Contains(out, "is being accessed by other users") {
+			return err
+		}
+	}
+}
+
+// ListDatabases returns the names of the databases in the cluster, in sorted
+// order.  The template0 and template1 databases made by initdb, and snapshots
+// made by Snapshot, are omitted; the "postgres" database is included.
+func (bp *BriefPG) ListDatabases(ctx context.Context) ([]string, error) {
+	if bp.state < stateServerStarted {
+		return nil, fmt.Errorf("Server not started; cannot list databases")
+	}
+	rows, err := bp.psqlRows(ctx, "ListDatabases", "postgres",
+		"SELECT datname FROM pg_database "+
+			"WHERE datname NOT IN ('template0', 'template1') ORDER BY datname")
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(rows))
+	for _, row := range rows {
+		if !strings.HasPrefix(row[0], snapshotPrefix) {
+			names = append(names, row[0])
+		}
+	}
+	return names, nil
+}
+
+// DatabaseExists reports whether the named database exists.
+func (bp *BriefPG) DatabaseExists(ctx context.Context, dbName string) (bool, error) {
+	if bp.state < stateServerStarted {
+		return false, fmt.Errorf("Server not started; cannot check database")
+	}
+	rows, err := bp.psqlRows(ctx, "DatabaseExists", "postgres",
+		"SELECT 1 FROM pg_database WHERE datname = "+quoteLiteral(dbName))
+	if err != nil {
+		return false, err
+	}
+	return len(rows) > 0, nil
+}
+
+// terminateConnections disconnects all sessions attached to dbName.
+func (bp *BriefPG) terminateConnections(ctx context.Context, dbName string) error {
+	scmd := fmt.Sprintf("SELECT pg_terminate_backend(pid) FROM pg_stat_activity "+
+		"WHERE datname = %s AND pid <> pg_backend_pid()", quoteLiteral(dbName))
+	_, err := bp.psql(ctx, "terminating connections", "postgres", scmd)
+	return err
+}
+
+// psql runs a SQL command against dbName, logging its output.  The combined
+// output is returned (even on failure) so that callers can examine it; what
+// describes the operation for error messages.
+func (bp *BriefPG) psql(ctx context.Context, what, dbName, scmd string) (string, error) {
+	cmd := exec.CommandContext(ctx, bp.pgCmds["psql"], "-X", "-c", scmd, bp.DBUri(dbName))
 	bp.logf("briefpg: %s\n", strings.Join(cmd.Args, " "))
 	cmdOut, err := cmd.CombinedOutput()
-	for _, line := range strings.Split(strings.TrimSpace(string(cmdOut)), "\n") {
+	out := strings.TrimSpace(string(cmdOut))
+	for _, line := range strings.Split(out, "\n") {
 		bp.logf("briefpg: %s\n", line)
 	}
 	if err != nil {
-		return "", wrapExecErr("CreateDB failed", cmd, err)
+		return out, wrapOutputErr(ctx, what+" failed", cmd, out, err)
 	}
-	return bp.DBUri(dbName), nil
+	return out, nil
 }
 
-// DumpDB writes the named database contents to w using pg_dump.  In a test
-// case, this can be used to dump the database in the event of a failure.
-func (bp *BriefPG) DumpDB(ctx context.Context, dbName string, w io.Writer) error {
+// Settings returns the effective values of the server's configuration
+// parameters, as reported by the running server, keyed by parameter name.
+// Values are formatted as by SHOW, so they include units where appropriate.
+func (bp *BriefPG) Settings(ctx context.Context) (map[string]string, error) {
 	if bp.state < stateServerStarted {
-		return fmt.Errorf("Server not started; cannot dump database")
+		return nil, fmt.Errorf("Server not started; cannot read settings")
 	}
-	cmd := exec.Command(bp.pgCmds["pg_dump"], bp.DBUri(dbName))
-	stdout, err := cmd.StdoutPipe()
+	rows, err := bp.psqlRows(ctx, "Settings", "postgres",
+		"SELECT name, current_setting(name) FROM pg_settings")
 	if err != nil {
-		return err
+		return nil, err
+	}
+	settings := make(map[string]string, len(rows))
+	for _, row := range rows {
+		if len(row) != 2 {
+			return nil, fmt.Errorf("Settings: unexpected row %q", row)
+		}
+		settings[row[0]] = row[1]
 	}
-	bp.logf("briefpg: starting dump: %s\n", strings.Join(cmd.Args, " "))
-	err = cmd.Start()
+	return settings, nil
+}
+
+// psqlRows runs a query against dbName, returning the result rows.
+func (bp *BriefPG) psqlRows(ctx context.Context, what, dbName, query string) ([][]string, error) {
+	qr, err := bp.query(ctx, what, dbName, query)
 	if err != nil {
+		return nil, err
+	}
+	return qr.Rows, nil
+}
+
+// DBUri returns the connection URI for a named database.  If the server is
+// listening on TCP, the URI uses the host:port form; otherwise it refers to
+// the Unix-domain socket in the temporary directory.
+//
+// If password authentication is in use (see OptAuth), the URI includes the
+// superuser's password.
+func (bp *BriefPG) DBUri(dbName string) string {
+	return bp.uri(dbName, superUser, bp.password)
+}
+
+// uri returns the connection URI for a named database, user and password.
+func (bp *BriefPG) uri(dbName, user, password string) string {
+	params := "user=" + url.QueryEscape(user)
+	if password != "" {
+		params += "&password=" + url.QueryEscape(password)
+	}
+	if bp.listenTCP {
+		return fmt.Sprintf("postgresql://%s:%d/%s?%s",
+			tcpListenAddr, bp.port, dbName, params)
+	}
+	return fmt.Sprintf("postgresql:///%s?host=%s&%s", dbName, bp.tmpDir, params)
+}
+
+// Stop stops the database server using the given shutdown mode, but unlike
+// Fini(), keeps the database cluster so that the server can be started again
+// with Start().  The server's TCP port, if any, is retained across restarts
+// when possible.
+func (bp *BriefPG) Stop(ctx context.Context, mode StopMode) error {
+	if bp.state != stateServerStarted {
+		return fmt.Errorf("Server not started; cannot stop")
+	}
+	if bp.shared != nil {
+		return fmt.Errorf("Server is shared; cannot stop")
+	}
+	switch mode {
+	case StopSmart, StopFast, StopImmediate:
+	default:
+		return fmt.Errorf("Invalid stop mode %q", mode)
+	}
+	if err := bp.stopServer(ctx, "Stop", mode); err != nil {
 		return err
 	}
-	_, err = io.Copy(w, stdout)
+	bp.state = stateInitialized
+	return nil
+}
+
+// Restart stops the database server with StopFast, and starts it again.
+func (bp *BriefPG) Restart(ctx context.Context) error {
+	if err := bp.Stop(ctx, StopFast); err != nil {
+		return fmt.Errorf("Restart failed: %w", err)
+	}
+	return bp.Start(ctx)
+}
+
+// Reload signals the database server to reload its configuration files, such
+// as postgresql.conf and pg_hba.conf.
+func (bp *BriefPG) Reload(ctx context.Context) error {
+	if bp.state != stateServerStarted {
+		return fmt.Errorf("Server not started; cannot reload")
+	}
+	cmd := exec.CommandContext(ctx, bp.pgCmds["pg_ctl"], "-s", "-D", bp.DbDir(), "reload")
+	bp.logf("briefpg: %s\n", strings.Join(cmd.Args, " "))
+	cmdOut, err := cmd.CombinedOutput()
 	if err != nil {
-		return err
+		bp.logf("briefpg: %s\n", string(cmdOut))
+		return wrapExecErr(ctx, "Reload failed", cmd, err)
 	}
-	if err := cmd.Wait(); err != nil {
-		return wrapExecErr("DumpDB failed", cmd, err)
+	return nil
+}
+
+// stopServer runs pg_ctl stop, using the given shutdown mode.
+func (bp *BriefPG) stopServer(ctx context.Context, what string, mode StopMode) error {
+	cmd := exec.CommandContext(ctx, bp.pgCmds["pg_ctl"], "-m", string(mode), "-w",
+		"-D", bp.DbDir(), "stop")
+	bp.logf("briefpg: %s\n", strings.Join(cmd.Args, " "))
+	cmdOut, err := cmd.CombinedOutput()
+	if err != nil {
+		bp.logf("briefpg: %s\n", string(cmdOut))
+		return wrapExecErr(ctx, what+" failed", cmd, err)
 	}
 	return nil
 }
 
-// DBUri returns the connection URI for a named database
-func (bp *BriefPG) DBUri(dbName string) string {
-	return fmt.Sprintf("postgresql:///%s?host=%s&user=postgres", dbName, bp.tmpDir)
+// abortStart stops a server whose startup was interrupted, if it got far
+// enough to be running.  This uses a fresh context, as the caller's context
+// has already been cancelled.
+func (bp *BriefPG) abortStart() {
+	if _, err := os.Stat(filepath.Join(bp.DbDir(), "postmaster.pid")); err != nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), abortTimeout)
+	defer cancel()
+	if err := bp.stopServer(ctx, "Stopping interrupted server", StopImmediate); err != nil {
+		bp.logf("briefpg: %v\n", err)
+	}
 }
 
 // Fini stops the database server, if running, and cleans it up
 func (bp *BriefPG) Fini(ctx context.Context) error {
+	if bp.state == stateDefunct {
+		return nil
+	}
+	if bp.shared != nil {
+		// The server outlives us, so drop the pool's databases.
+		perr := bp.StopPool(ctx)
+		if err := bp.finiShared(ctx); err != nil {
+			return err
+		}
+		return perr
+	}
+	bp.stopPoolFiller()
 	if bp.state >= stateServerStarted {
-		cmd := exec.Command(bp.pgCmds["pg_ctl"], "-m", "immediate", "-w",
-			"-D", bp.DbDir(), "stop")
-		bp.logf("briefpg: %s\n", strings.Join(cmd.Args, " "))
-		cmdOut, err := cmd.CombinedOutput()
-		if err != nil {
-			bp.logf("briefpg: %s\n", string(cmdOut))
-			return wrapExecErr("Fini failed", cmd, err)
+		if err := bp.stopServer(ctx, "Fini", StopImmediate); err != nil {
+			return err
 		}
 	}
+	bp.stopWatchdog()
 
 	if bp.state >= statePresent {
 		if bp.madeTmpDir {
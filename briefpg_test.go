@@ -14,11 +14,17 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"errors"
+	"fmt"
 	"io/ioutil"
+	"net"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"regexp"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestPostgresInstalled(t *testing.T) {
@@ -215,3 +221,365 @@ func TestDumpDB(t *testing.T) {
 		t.Fatalf("Expected DumpDB to fail: %s", outBuf.String())
 	}
 }
+
+func TestListenTCP(t *testing.T) {
+	ctx := context.Background()
+	bpg, err := New(OptLogFunc(t.Logf), OptListenTCP())
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if bpg.Port() != 0 {
+		t.Fatalf("Expected no port before Start: %d", bpg.Port())
+	}
+
+	err = bpg.Start(ctx)
+	if err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer bpg.MustFini(ctx)
+
+	port := bpg.Port()
+	if port == 0 {
+		t.Fatalf("Expected a port after Start")
+	}
+	hostPort := fmt.Sprintf("127.0.0.1:%d", port)
+	if !strings.Contains(bpg.DBUri("test_db"), hostPort) {
+		t.Fatalf("Expected %s in URI: %s", hostPort, bpg.DBUri("test_db"))
+	}
+	conn, err := net.Dial("tcp", hostPort)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	conn.Close()
+
+	_, err = bpg.CreateDB(ctx, "test_db", "")
+	if err != nil {
+		t.Fatalf("CreateDB failed: %v", err)
+	}
+
+	err = bpg.SetOption(OptListenTCP())
+	if err == nil {
+		t.Fatalf("Expected SetOption to fail")
+	}
+}
+
+func TestCloneDB(t *testing.T) {
+	ctx := context.Background()
+	bpg, err := New(OptLogFunc(t.Logf))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	_, err = bpg.CloneDB(ctx, "base_db", "clone_db")
+	if err == nil {
+		t.Fatalf("Expected CloneDB to fail")
+	}
+
+	err = bpg.Start(ctx)
+	if err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer bpg.MustFini(ctx)
+
+	_, err = bpg.CreateDB(ctx, "base_db", "")
+	if err != nil {
+		t.Fatalf("CreateDB failed: %v", err)
+	}
+	err = bpg.MakeTemplate(ctx, "base_db")
+	if err != nil {
+		t.Fatalf("MakeTemplate failed: %v", err)
+	}
+
+	// Hold a connection to the template open, so that CloneDB has to
+	// terminate it.
+	psql := exec.Command(bpg.pgCmds["psql"], "-X", bpg.DBUri("base_db"))
+	stdin, err := psql.StdinPipe()
+	if err != nil {
+		t.Fatalf("StdinPipe failed: %v", err)
+	}
+	if err = psql.Start(); err != nil {
+		t.Fatalf("psql failed: %v", err)
+	}
+	defer psql.Wait()
+	defer stdin.Close()
+	for {
+		rows, err := bpg.psqlRows(ctx, "test", "postgres",
+			"SELECT 1 FROM pg_stat_activity WHERE datname = 'base_db'")
+		if err != nil {
+			t.Fatalf("psqlRows failed: %v", err)
+		}
+		if len(rows) > 0 {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	uri, err := bpg.CloneDB(ctx, "base_db", "clone_db")
+	if err != nil {
+		t.Fatalf("CloneDB of busy template failed: %v", err)
+	}
+	if uri != bpg.DBUri("clone_db") {
+		t.Fatalf("Unexpected URI: %s", uri)
+	}
+
+	_, err = bpg.CloneDB(ctx, "garbage_db", "clone_db2")
+	if err == nil {
+		t.Fatalf("Expected CloneDB to fail")
+	}
+}
+
+func TestDropDB(t *testing.T) {
+	ctx := context.Background()
+	bpg, err := New(OptLogFunc(t.Logf))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	err = bpg.DropDB(ctx, "test_db", false)
+	if err == nil {
+		t.Fatalf("Expected DropDB to fail")
+	}
+
+	err = bpg.Start(ctx)
+	if err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer bpg.MustFini(ctx)
+
+	// A name which would inject SQL if it weren't quoted
+	odd := `odd"; DROP DATABASE postgres; --`
+	for _, name := range []string{"test_db", odd} {
+		_, err = bpg.CreateDB(ctx, name, "")
+		if err != nil {
+			t.Fatalf("CreateDB %q failed: %v", name, err)
+		}
+	}
+	names, err := bpg.ListDatabases(ctx)
+	if err != nil {
+		t.Fatalf("ListDatabases failed: %v", err)
+	}
+	want := fmt.Sprint([]string{odd, "postgres", "test_db"})
+	if fmt.Sprint(names) != want {
+		t.Fatalf("Unexpected databases %q; wanted %s", names, want)
+	}
+
+	// Hold a connection open, so that only a forced drop succeeds.
+	psql := exec.Command(bpg.pgCmds["psql"], "-X", bpg.DBUri(odd))
+	stdin, err := psql.StdinPipe()
+	if err != nil {
+		t.Fatalf("StdinPipe failed: %v", err)
+	}
+	if err = psql.Start(); err != nil {
+		t.Fatalf("psql failed: %v", err)
+	}
+	defer psql.Wait()
+	defer stdin.Close()
+	for {
+		rows, err := bpg.psqlRows(ctx, "test", "postgres",
+			"SELECT 1 FROM pg_stat_activity WHERE datname = "+quoteLiteral(odd))
+		if err != nil {
+			t.Fatalf("psqlRows failed: %v", err)
+		}
+		if len(rows) > 0 {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if err = bpg.DropDB(ctx, odd, false); err == nil {
+		t.Fatalf("Expected DropDB of busy database to fail")
+	}
+	for _, name := range []string{odd, "test_db"} {
+		if err = bpg.DropDB(ctx, name, true); err != nil {
+			t.Fatalf("DropDB %q failed: %v", name, err)
+		}
+		exists, err := bpg.DatabaseExists(ctx, name)
+		if err != nil {
+			t.Fatalf("DatabaseExists failed: %v", err)
+		}
+		if exists {
+			t.Fatalf("Expected %q to be dropped", name)
+		}
+	}
+	exists, err := bpg.DatabaseExists(ctx, "postgres")
+	if err != nil || !exists {
+		t.Fatalf("Expected postgres to exist: %v", err)
+	}
+	if err = bpg.DropDB(ctx, "test_db", false); err != nil {
+		t.Fatalf("DropDB of missing database failed: %v", err)
+	}
+}
+
+func TestQuote(t *testing.T) {
+	if q := quoteIdent(`my"db`); q != `"my""db"` {
+		t.Fatalf("Unexpected quoteIdent result: %s", q)
+	}
+	if q := quoteLiteral(`it's`); q != `'it''s'` {
+		t.Fatalf("Unexpected quoteLiteral result: %s", q)
+	}
+	if q := quoteConfValue(`it's C:\`); q != `'it''s C:\\'` {
+		t.Fatalf("Unexpected quoteConfValue result: %s", q)
+	}
+}
+
+func TestContextCancel(t *testing.T) {
+	bpg, err := New(OptLogFunc(t.Logf))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer bpg.MustFini(context.Background())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = bpg.Start(ctx)
+	if err == nil || !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected Start to be cancelled: %v", err)
+	}
+	if !strings.Contains(err.Error(), "initDB") {
+		t.Fatalf("Expected error to name the interrupted stage: %v", err)
+	}
+
+	// A later Start with a live context should succeed.
+	err = bpg.Start(context.Background())
+	if err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+
+	_, err = bpg.CreateDB(ctx, "test_db", "")
+	if err == nil || !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected CreateDB to be cancelled: %v", err)
+	}
+}
+
+func TestStopRestartReload(t *testing.T) {
+	ctx := context.Background()
+	bpg, err := New(OptLogFunc(t.Logf), OptListenTCP())
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer bpg.MustFini(ctx)
+
+	if err = bpg.Stop(ctx, StopFast); err == nil {
+		t.Fatalf("Expected Stop to fail")
+	}
+	if err = bpg.Reload(ctx); err == nil {
+		t.Fatalf("Expected Reload to fail")
+	}
+
+	err = bpg.Start(ctx)
+	if err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	if err = bpg.Start(ctx); err == nil {
+		t.Fatalf("Expected second Start to fail")
+	}
+	_, err = bpg.CreateDB(ctx, "test_db", "")
+	if err != nil {
+		t.Fatalf("CreateDB failed: %v", err)
+	}
+	port := bpg.Port()
+
+	if err = bpg.Stop(ctx, StopMode("bogus")); err == nil {
+		t.Fatalf("Expected Stop with bogus mode to fail")
+	}
+	for _, mode := range []StopMode{StopSmart, StopFast, StopImmediate} {
+		if err = bpg.Stop(ctx, mode); err != nil {
+			t.Fatalf("Stop(%s) failed: %v", mode, err)
+		}
+		if err = bpg.DumpDB(ctx, "test_db", ioutil.Discard); err == nil {
+			t.Fatalf("Expected DumpDB to fail while stopped")
+		}
+		if err = bpg.Start(ctx); err != nil {
+			t.Fatalf("Start after Stop(%s) failed: %v", mode, err)
+		}
+		if bpg.Port() != port {
+			t.Fatalf("Port changed across Stop(%s): %d -> %d", mode,
+				port, bpg.Port())
+		}
+		// The database should have survived.
+		if err = bpg.DumpDB(ctx, "test_db", ioutil.Discard); err != nil {
+			t.Fatalf("DumpDB failed: %v", err)
+		}
+	}
+
+	if err = bpg.Restart(ctx); err != nil {
+		t.Fatalf("Restart failed: %v", err)
+	}
+	if bpg.Port() != port {
+		t.Fatalf("Port changed across Restart: %d -> %d", port, bpg.Port())
+	}
+	if err = bpg.Reload(ctx); err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	if err = bpg.DumpDB(ctx, "test_db", ioutil.Discard); err != nil {
+		t.Fatalf("DumpDB failed: %v", err)
+	}
+}
+
+func TestSettings(t *testing.T) {
+	ctx := context.Background()
+	bpg, err := New(OptLogFunc(t.Logf), OptSetting("work_mem", "5MB"),
+		OptSettings(map[string]string{
+			"application_name": "it's briefpg",
+			"log_statement":    "ddl",
+		}))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer bpg.MustFini(ctx)
+
+	_, err = bpg.Settings(ctx)
+	if err == nil {
+		t.Fatalf("Expected Settings to fail")
+	}
+	err = bpg.SetOption(OptSetting("bogus name", "1"))
+	if err == nil {
+		t.Fatalf("Expected SetOption to fail")
+	}
+	for _, name := range []string{"port", "Listen_Addresses", "lc_messages"} {
+		if err = bpg.SetOption(OptSetting(name, "1")); err == nil {
+			t.Fatalf("Expected SetOption(%s) to fail", name)
+		}
+	}
+
+	err = bpg.Start(ctx)
+	if err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	settings, err := bpg.Settings(ctx)
+	if err != nil {
+		t.Fatalf("Settings failed: %v", err)
+	}
+	expected := map[string]string{
+		"work_mem":         "5MB",
+		"application_name": "it's briefpg",
+		"log_statement":    "ddl",
+		"fsync":            "off",
+	}
+	for name, value := range expected {
+		if settings[name] != value {
+			t.Fatalf("Expected %s = %q, got %q", name, value, settings[name])
+		}
+	}
+
+	// Changes made while running take effect on Reload.
+	err = bpg.SetOption(OptSetting("log_statement", "all"))
+	if err != nil {
+		t.Fatalf("SetOption failed: %v", err)
+	}
+	err = bpg.Reload(ctx)
+	if err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	// The reload is asynchronous; give it a moment.
+	for i := 0; i < 50; i++ {
+		settings, err = bpg.Settings(ctx)
+		if err != nil {
+			t.Fatalf("Settings failed: %v", err)
+		}
+		if settings["log_statement"] == "all" {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	t.Fatalf("Expected log_statement = all, got %q", settings["log_statement"])
+}
@@ -0,0 +1,249 @@
+/*
+ * COPYRIGHT 2020 Brightgate Inc.  All rights reserved.
+ *
+ * This copyright notice is Copyright Management Information under 17 USC 1202
+ * and is included to protect this work and deter copyright infringement.
+ * Removal or alteration of this Copyright Management Information without the
+ * express written permission of Brightgate Inc is prohibited, and any
+ * such unauthorized removal or alteration will be a violation of federal law.
+ */
+
+package briefpg
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+//
+// The initdb cache is a directory of entries, each named by a hash of the
+// inputs which influence initdb's output.  Each entry contains a pristine
+// cluster ("data") and a description of how it was made ("meta.json").
+// Entries are built in a private staging directory and published with an
+// atomic rename, so an entry is never visible in a partially built state, and
+// is never modified afterwards.  Postgres rewrites files in place, so the
+// cached cluster is always copied (or reflinked, where the filesystem supports
+// it) rather than hard-linked into DbDir().
+//
+
+const (
+	cacheDataDir  = "data"
+	cacheMetaFile = "meta.json"
+
+	// cacheScratchAge is how old an abandoned staging directory must be
+	// before it is removed.
+	cacheScratchAge = time.Hour
+)
+
+// localeEnv lists the environment variables which influence initdb's choice
+// of locale.
+var localeEnv = []string{"LC_ALL", "LC_COLLATE", "LC_CTYPE", "LC_MESSAGES",
+	"LC_MONETARY", "LC_NUMERIC", "LC_TIME", "LANG"}
+
+// cacheMeta describes how a cache entry was made.
+type cacheMeta struct {
+	InitDB      string `json:"initdb"`
+	Fingerprint string `json:"fingerprint"`
+	PgVer       string `json:"pgver"`
+}
+
+// binaryFingerprint summarizes the identity of the file at path, such that
+// it changes when the file is replaced (for example, by a package upgrade).
+func binaryFingerprint(path string) (string, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d-%d", fi.Size(), fi.ModTime().UnixNano()), nil
+}
+
+// cacheKey computes the name of the cache entry for this instance.
+func (bp *BriefPG) cacheKey(fingerprint string) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "pgver=%s\n", bp.PgVer())
+	fmt.Fprintf(h, "initdb=%s\n", bp.pgCmds["initdb"])
+	fmt.Fprintf(h, "fingerprint=%s\n", fingerprint)
+	fmt.Fprintf(h, "args=%s\n", strings.Join(bp.initDBArgs(), "\x00"))
+	for _, v := range localeEnv {
+		fmt.Fprintf(h, "%s=%s\n", v, os.Getenv(v))
+	}
+	return hex.EncodeToString(h.Sum(nil)[:16])
+}
+
+// initDBFromCache populates DbDir() from the initdb cache, first creating
+// the cache entry if necessary.
+func (bp *BriefPG) initDBFromCache(ctx context.Context) error {
+	initdb := bp.pgCmds["initdb"]
+	fingerprint, err := binaryFingerprint(initdb)
+	if err != nil {
+		return fmt.Errorf("Failed to fingerprint initdb: %w", err)
+	}
+	if err := os.MkdirAll(bp.initDBCache, 0700); err != nil {
+		return fmt.Errorf("Failed to make initdb cache dir: %w", err)
+	}
+	bp.pruneInitDBCache()
+
+	entry := filepath.Join(bp.initDBCache, bp.cacheKey(fingerprint))
+	if _, err := os.Stat(filepath.Join(entry, cacheDataDir, "PG_VERSION")); err != nil {
+		meta := cacheMeta{
+			InitDB:      initdb,
+			Fingerprint: fingerprint,
+			PgVer:       bp.PgVer(),
+		}
+		if err := bp.populateInitDBCache(ctx, entry, meta); err != nil {
+			return err
+		}
+	}
+
+	bp.logf("briefpg: copying cached cluster %s\n", entry)
+	if err := copyTree(filepath.Join(entry, cacheDataDir), bp.DbDir()); err != nil {
+		bp.logf("briefpg: failed to copy cached cluster: %v; running initdb\n", err)
+		os.RemoveAll(bp.DbDir())
+		return bp.runInitDB(ctx, bp.DbDir())
+	}
+	return nil
+}
+
+// populateInitDBCache runs initdb to create the cache entry at entry.  If
+// another process publishes the same entry first, its entry is used instead.
+func (bp *BriefPG) populateInitDBCache(ctx context.Context, entry string, meta cacheMeta) error {
+	if _, err := os.Stat(entry); err == nil {
+		// The entry is present but incomplete; it can't have been
+		// published by us, so discard it.
+		removeCacheEntry(bp.initDBCache, entry)
+	}
+
+	staging, err := ioutil.TempDir(bp.initDBCache, ".tmp-")
+	if err != nil {
+		return fmt.Errorf("Failed to make initdb cache staging dir: %w", err)
+	}
+	defer os.RemoveAll(staging)
+
+	if err := bp.runInitDB(ctx, filepath.Join(staging, cacheDataDir)); err != nil {
+		return err
+	}
+	metab, err := json.Marshal(meta)
+	if err != nil {
+		return fmt.Errorf("Failed to encode initdb cache metadata: %w", err)
+	}
+	err = ioutil.WriteFile(filepath.Join(staging, cacheMetaFile), metab, 0600)
+	if err != nil {
+		return fmt.Errorf("Failed to write initdb cache metadata: %w", err)
+	}
+
+	bp.logf("briefpg: saving initdb output to cache %s\n", entry)
+	if err := os.Rename(staging, entry); err != nil {
+		// Lost a race with another process; use its entry.
+		if _, serr := os.Stat(filepath.Join(entry, cacheDataDir, "PG_VERSION")); serr == nil {
+			return nil
+		}
+		return fmt.Errorf("Failed to publish initdb cache entry: %w", err)
+	}
+	return nil
+}
+
+// pruneInitDBCache removes cache entries whose initdb binary has been
+// upgraded or removed since the entry was made, as well as scratch
+// directories abandoned by crashed processes.
+func (bp *BriefPG) pruneInitDBCache() {
+	entries, err := ioutil.ReadDir(bp.initDBCache)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		dir := filepath.Join(bp.initDBCache, e.Name())
+		if !e.IsDir() {
+			continue
+		}
+		if strings.HasPrefix(e.Name(), ".") {
+			if time.Since(e.ModTime()) > cacheScratchAge {
+				os.RemoveAll(dir)
+			}
+			continue
+		}
+
+		var meta cacheMeta
+		metab, err := ioutil.ReadFile(filepath.Join(dir, cacheMetaFile))
+		if err != nil || json.Unmarshal(metab, &meta) != nil {
+			continue
+		}
+		fingerprint, err := binaryFingerprint(meta.InitDB)
+		if err == nil && fingerprint == meta.Fingerprint {
+			continue
+		}
+		bp.logf("briefpg: removing stale initdb cache entry %s (%s)\n",
+			dir, meta.PgVer)
+		removeCacheEntry(bp.initDBCache, dir)
+	}
+}
+
+// removeCacheEntry removes a cache entry.  The entry is first renamed out of
+// the way, so that no other process can observe it half-deleted.
+func removeCacheEntry(cacheDir, entry string) {
+	doomed := filepath.Join(cacheDir,
+		fmt.Sprintf(".stale-%d-%s", os.Getpid(), filepath.Base(entry)))
+	if err := os.Rename(entry, doomed); err != nil {
+		return
+	}
+	os.RemoveAll(doomed)
+}
+
+// copyTree recursively copies the directory src to dst, which must not
+// exist.  Permissions are preserved.
+func copyTree(src, dst string) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		switch {
+		case info.IsDir():
+			return os.Mkdir(target, info.Mode().Perm())
+		case info.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case info.Mode().IsRegular():
+			return copyFile(path, target, info.Mode().Perm())
+		}
+		return nil
+	})
+}
+
+// copyFile copies the regular file src to dst, cloning the file's contents if
+// the filesystem supports it.
+func copyFile(src, dst string, perm os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	if cloneFile(out, in) != nil {
+		if _, err := io.Copy(out, in); err != nil {
+			out.Close()
+			return err
+		}
+	}
+	return out.Close()
+}
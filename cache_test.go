@@ -0,0 +1,115 @@
+/*
+ * COPYRIGHT 2020 Brightgate Inc.  All rights reserved.
+ *
+ * This copyright notice is Copyright Management Information under 17 USC 1202
+ * and is included to protect this work and deter copyright infringement.
+ * Removal or alteration of this Copyright Management Information without the
+ * express written permission of Brightgate Inc is prohibited, and any
+ * such unauthorized removal or alteration will be a violation of federal law.
+ */
+
+package briefpg
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestInitDBCache(t *testing.T) {
+	ctx := context.Background()
+	cacheDir, err := ioutil.TempDir("", "test.")
+	if err != nil {
+		t.Fatalf("TempDir failed: %v", err)
+	}
+	defer os.RemoveAll(cacheDir)
+
+	// The first instance populates the cache; the second uses it.
+	for i := 0; i < 2; i++ {
+		bpg, err := New(OptLogFunc(t.Logf), OptInitDBCache(cacheDir))
+		if err != nil {
+			t.Fatalf("New failed: %v", err)
+		}
+		err = bpg.Start(ctx)
+		if err != nil {
+			t.Fatalf("Start failed: %v", err)
+		}
+		_, err = bpg.CreateDB(ctx, "test_db", "")
+		if err != nil {
+			t.Fatalf("CreateDB failed: %v", err)
+		}
+		bpg.MustFini(ctx)
+
+		entries, err := filepath.Glob(filepath.Join(cacheDir, "*", cacheMetaFile))
+		if err != nil || len(entries) != 1 {
+			t.Fatalf("Expected one cache entry: %v %v", entries, err)
+		}
+	}
+
+	// Simulate an upgrade of initdb by corrupting the entry's fingerprint;
+	// the entry should be replaced.
+	entries, _ := filepath.Glob(filepath.Join(cacheDir, "*", cacheMetaFile))
+	err = ioutil.WriteFile(entries[0], []byte(`{"initdb": "/bogus/initdb"}`), 0600)
+	if err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	bpg, err := New(OptLogFunc(t.Logf), OptInitDBCache(cacheDir))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	err = bpg.Start(ctx)
+	if err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	bpg.MustFini(ctx)
+	entries, _ = filepath.Glob(filepath.Join(cacheDir, "*", cacheMetaFile))
+	if len(entries) != 1 {
+		t.Fatalf("Expected one cache entry: %v", entries)
+	}
+	metab, err := ioutil.ReadFile(entries[0])
+	if err != nil || string(metab) == `{"initdb": "/bogus/initdb"}` {
+		t.Fatalf("Expected stale entry to be replaced: %s %v", metab, err)
+	}
+}
+
+func TestCopyTree(t *testing.T) {
+	src, err := ioutil.TempDir("", "test.")
+	if err != nil {
+		t.Fatalf("TempDir failed: %v", err)
+	}
+	defer os.RemoveAll(src)
+	dst, err := ioutil.TempDir("", "test.")
+	if err != nil {
+		t.Fatalf("TempDir failed: %v", err)
+	}
+	defer os.RemoveAll(dst)
+
+	if err := os.Mkdir(filepath.Join(src, "sub"), 0700); err != nil {
+		t.Fatalf("Mkdir failed: %v", err)
+	}
+	err = ioutil.WriteFile(filepath.Join(src, "sub", "file"), []byte("hello"), 0600)
+	if err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	if err := os.Symlink("sub/file", filepath.Join(src, "link")); err != nil {
+		t.Fatalf("Symlink failed: %v", err)
+	}
+
+	copyDir := filepath.Join(dst, "copy")
+	if err := copyTree(src, copyDir); err != nil {
+		t.Fatalf("copyTree failed: %v", err)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(copyDir, "link"))
+	if err != nil || string(b) != "hello" {
+		t.Fatalf("Unexpected copy: %q %v", b, err)
+	}
+	fi, err := os.Stat(filepath.Join(copyDir, "sub", "file"))
+	if err != nil || fi.Mode().Perm() != 0600 {
+		t.Fatalf("Unexpected copy mode: %v %v", fi, err)
+	}
+	if err := copyTree(src, copyDir); err == nil {
+		t.Fatalf("Expected copyTree to fail on existing dst")
+	}
+}
@@ -0,0 +1,24 @@
+//go:build !linux
+// +build !linux
+
+/*
+ * COPYRIGHT 2020 Brightgate Inc.  All rights reserved.
+ *
+ * This copyright notice is Copyright Management Information under 17 USC 1202
+ * and is included to protect this work and deter copyright infringement.
+ * Removal or alteration of this Copyright Management Information without the
+ * express written permission of Brightgate Inc is prohibited, and any
+ * such unauthorized removal or alteration will be a violation of federal law.
+ */
+
+package briefpg
+
+import (
+	"errors"
+	"os"
+)
+
+// cloneFile is not supported on this platform; callers fall back to copying.
+func cloneFile(dst, src *os.File) error {
+	return errors.New("file cloning not supported")
+}
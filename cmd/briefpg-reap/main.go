@@ -0,0 +1,57 @@
+/*
+ * COPYRIGHT 2020 Brightgate Inc.  All rights reserved.
+ *
+ * This copyright notice is Copyright Management Information under 17 USC 1202
+ * and is included to protect this work and deter copyright infringement.
+ * Removal or alteration of this Copyright Management Information without the
+ * express written permission of Brightgate Inc is prohibited, and any
+ * such unauthorized removal or alteration will be a violation of federal law.
+ */
+
+// Command briefpg-reap cleans up after briefpg instances whose owning process
+// exited without calling Fini(), for example because a test binary panicked
+// or was killed.  Orphaned Postgres servers are stopped, and their temporary
+// directories are removed.  It is suitable for running periodically on CI
+// machines.
+//
+// Usage:
+//
+//	briefpg-reap [-dir tmpdir] [-n] [-v]
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/danielbprice/briefpg"
+)
+
+func main() {
+	dir := flag.String("dir", os.TempDir(), "directory to search for briefpg instances")
+	dryRun := flag.Bool("n", false, "report what would be reaped, but change nothing")
+	verbose := flag.Bool("v", false, "verbose output")
+	flag.Parse()
+	if flag.NArg() != 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	ro := briefpg.ReapOptions{
+		Dir:    *dir,
+		DryRun: *dryRun,
+	}
+	if *verbose {
+		ro.Logf = log.Printf
+	}
+
+	reaped, err := briefpg.Reap(context.Background(), ro)
+	for _, d := range reaped {
+		fmt.Println(d)
+	}
+	if err != nil {
+		log.Fatalf("briefpg-reap: %v", err)
+	}
+}
@@ -0,0 +1,160 @@
+/*
+ * COPYRIGHT 2020 Brightgate Inc.  All rights reserved.
+ *
+ * This copyright notice is Copyright Management Information under 17 USC 1202
+ * and is included to protect this work and deter copyright infringement.
+ * Removal or alteration of this Copyright Management Information without the
+ * express written permission of Brightgate Inc is prohibited, and any
+ * such unauthorized removal or alteration will be a violation of federal law.
+ */
+
+package briefpg
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+)
+
+// DumpFormat is the format of a database dump, as understood by pg_dump's
+// --format option.
+type DumpFormat string
+
+// Dump formats.  A plain dump is a SQL script, restored with psql; the others
+// are archives, restored with pg_restore.  Directory dumps can't be streamed.
+const (
+	DumpPlain     DumpFormat = "plain"
+	DumpCustom    DumpFormat = "custom"
+	DumpDirectory DumpFormat = "directory"
+	DumpTar       DumpFormat = "tar"
+)
+
+// DumpOptions controls the pg_dump invocation made by DumpDBWithOptions and
+// DumpDBDir.  The zero value dumps everything as a plain SQL script, as
+// DumpDB does.  Table and schema patterns use pg_dump's syntax, in which *
+// and ? are wildcards.
+type DumpOptions struct {
+	Format         DumpFormat // Dump format; "" for DumpPlain
+	SchemaOnly     bool       // Dump only object definitions
+	DataOnly       bool       // Dump only data
+	Tables         []string   // Dump only tables matching these patterns
+	ExcludeTables  []string   // Skip tables matching these patterns
+	Schemas        []string   // Dump only schemas matching these patterns
+	ExcludeSchemas []string   // Skip schemas matching these patterns
+	NoOwner        bool       // Omit commands which set object ownership
+	NoPrivileges   bool       // Omit GRANT and REVOKE commands
+}
+
+// args returns the pg_dump arguments which implement the options.
+func (do DumpOptions) args() ([]string, error) {
+	if do.SchemaOnly && do.DataOnly {
+		return nil, fmt.Errorf("SchemaOnly and DataOnly are exclusive")
+	}
+	format := do.Format
+	if format == "" {
+		format = DumpPlain
+	}
+	switch format {
+	case DumpPlain, DumpCustom, DumpDirectory, DumpTar:
+	default:
+		return nil, fmt.Errorf("unknown dump format %q", format)
+	}
+	args := []string{"--format=" + string(format)}
+	if do.SchemaOnly {
+		args = append(args, "--schema-only")
+	}
+	if do.DataOnly {
+		args = append(args, "--data-only")
+	}
+	for _, p := range do.Tables {
+		args = append(args, "--table="+p)
+	}
+	for _, p := range do.ExcludeTables {
+		args = append(args, "--exclude-table="+p)
+	}
+	for _, p := range do.Schemas {
+		args = append(args, "--schema="+p)
+	}
+	for _, p := range do.ExcludeSchemas {
+		args = append(args, "--exclude-schema="+p)
+	}
+	if do.NoOwner {
+		args = append(args, "--no-owner")
+	}
+	if do.NoPrivileges {
+		args = append(args, "--no-privileges")
+	}
+	return args, nil
+}
+
+// DumpDB writes the named database contents to w using pg_dump.  In a test
+// case, this can be used to dump the database in the event of a failure.
+func (bp *BriefPG) DumpDB(ctx context.Context, dbName string, w io.Writer) error {
+	return bp.DumpDBWithOptions(ctx, dbName, w, DumpOptions{})
+}
+
+// DumpDBWithOptions writes the named database contents to w using pg_dump,
+// as controlled by do.  Directory dumps can't be written to a stream; use
+// DumpDBDir for those.
+func (bp *BriefPG) DumpDBWithOptions(ctx context.Context, dbName string, w io.Writer, do DumpOptions) error {
+	if bp.state < stateServerStarted {
+		return fmt.Errorf("Server not started; cannot dump database")
+	}
+	if do.Format == DumpDirectory {
+		return fmt.Errorf("DumpDB cannot stream a directory dump; use DumpDBDir")
+	}
+	args, err := do.args()
+	if err != nil {
+		return fmt.Errorf("DumpDB failed: %w", err)
+	}
+	cmd := exec.CommandContext(ctx, bp.pgCmds["pg_dump"],
+		append(args, bp.DBUri(dbName))...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	bp.logf("briefpg: starting dump: %s\n", strings.Join(cmd.Args, " "))
+	err = cmd.Start()
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, stdout)
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return err
+	}
+	if err := cmd.Wait(); err != nil {
+		out := strings.TrimSpace(stderr.String())
+		return wrapOutputErr(ctx, "DumpDB failed", cmd, out, err)
+	}
+	return nil
+}
+
+// DumpDBDir writes the named database contents to dir as a directory
+// archive, which can be restored with RestoreDBDir; do.Format is ignored.
+// pg_dump creates dir, which must not already exist.
+func (bp *BriefPG) DumpDBDir(ctx context.Context, dbName, dir string, do DumpOptions) error {
+	if bp.state < stateServerStarted {
+		return fmt.Errorf("Server not started; cannot dump database")
+	}
+	do.Format = DumpDirectory
+	args, err := do.args()
+	if err != nil {
+		return fmt.Errorf("DumpDBDir failed: %w", err)
+	}
+	cmd := exec.CommandContext(ctx, bp.pgCmds["pg_dump"],
+		append(args, "--file="+dir, bp.DBUri(dbName))...)
+	bp.logf("briefpg: starting dump: %s\n", strings.Join(cmd.Args, " "))
+	cmdOut, err := cmd.CombinedOutput()
+	if err != nil {
+		out := strings.TrimSpace(string(cmdOut))
+		return wrapOutputErr(ctx, "DumpDBDir failed", cmd, out, err)
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+/*
+ * COPYRIGHT 2020 Brightgate Inc.  All rights reserved.
+ *
+ * This copyright notice is Copyright Management Information under 17 USC 1202
+ * and is included to protect this work and deter copyright infringement.
+ * Removal or alteration of this Copyright Management Information without the
+ * express written permission of Brightgate Inc is prohibited, and any
+ * such unauthorized removal or alteration will be a violation of federal law.
+ */
+
+package briefpg
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDumpOptionsArgs(t *testing.T) {
+	args, err := DumpOptions{}.args()
+	if err != nil || !reflect.DeepEqual(args, []string{"--format=plain"}) {
+		t.Fatalf("Unexpected args %q, %v", args, err)
+	}
+	args, err = DumpOptions{
+		Format:         DumpCustom,
+		SchemaOnly:     true,
+		Tables:         []string{"a*", "b"},
+		ExcludeTables:  []string{"c"},
+		Schemas:        []string{"public"},
+		ExcludeSchemas: []string{"audit"},
+		NoOwner:        true,
+		NoPrivileges:   true,
+	}.args()
+	want := []string{"--format=custom", "--schema-only", "--table=a*",
+		"--table=b", "--exclude-table=c", "--schema=public",
+		"--exclude-schema=audit", "--no-owner", "--no-privileges"}
+	if err != nil || !reflect.DeepEqual(args, want) {
+		t.Fatalf("Unexpected args %q, %v", args, err)
+	}
+	if _, err = (DumpOptions{SchemaOnly: true, DataOnly: true}).args(); err == nil {
+		t.Fatalf("Expected SchemaOnly with DataOnly to fail")
+	}
+	if _, err = (DumpOptions{Format: "xml"}).args(); err == nil {
+		t.Fatalf("Expected unknown format to fail")
+	}
+}
+
+func TestDumpDBWithOptions(t *testing.T) {
+	ctx := context.Background()
+	bpg, err := New(OptLogFunc(t.Logf))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if err = bpg.Start(ctx); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer bpg.MustFini(ctx)
+	if _, err = bpg.CreateDB(ctx, "test_db", ""); err != nil {
+		t.Fatalf("CreateDB failed: %v", err)
+	}
+	err = bpg.ExecSQL(ctx, "test_db", "CREATE TABLE keep (s text);\n"+
+		"CREATE TABLE skip (s text);\n"+
+		"INSERT INTO keep VALUES ('kept_value');\n"+
+		"INSERT INTO skip VALUES ('skipped_value');\n"+
+		"GRANT SELECT ON keep TO PUBLIC;\n", ExecOptions{})
+	if err != nil {
+		t.Fatalf("ExecSQL failed: %v", err)
+	}
+
+	dump := func(do DumpOptions) string {
+		t.Helper()
+		var buf bytes.Buffer
+		if err := bpg.DumpDBWithOptions(ctx, "test_db", &buf, do); err != nil {
+			t.Fatalf("DumpDBWithOptions(%+v) failed: %v", do, err)
+		}
+		return buf.String()
+	}
+
+	out := dump(DumpOptions{SchemaOnly: true})
+	if !strings.Contains(out, "CREATE TABLE public.keep") ||
+		strings.Contains(out, "kept_value") {
+		t.Fatalf("Unexpected schema-only dump: %s", out)
+	}
+	out = dump(DumpOptions{DataOnly: true, ExcludeTables: []string{"sk*"}})
+	if strings.Contains(out, "CREATE TABLE") || !strings.Contains(out, "kept_value") ||
+		strings.Contains(out, "skipped_value") {
+		t.Fatalf("Unexpected data-only dump: %s", out)
+	}
+	out = dump(DumpOptions{Tables: []string{"keep"}, NoOwner: true, NoPrivileges: true})
+	if strings.Contains(out, "skip") || strings.Contains(out, "OWNER TO") ||
+		strings.Contains(out, "GRANT") {
+		t.Fatalf("Unexpected filtered dump: %s", out)
+	}
+	out = dump(DumpOptions{Format: DumpCustom})
+	if !strings.HasPrefix(out, "PGDMP") {
+		t.Fatalf("Expected custom format archive")
+	}
+
+	var buf bytes.Buffer
+	err = bpg.DumpDBWithOptions(ctx, "test_db", &buf, DumpOptions{Format: DumpDirectory})
+	if err == nil {
+		t.Fatalf("Expected streamed directory dump to fail")
+	}
+	err = bpg.DumpDBWithOptions(ctx, "test_db", &buf, DumpOptions{Tables: []string{"missing"}})
+	if err == nil || !strings.Contains(err.Error(), "no matching tables") {
+		t.Fatalf("Expected dump of missing table to fail; got %v", err)
+	}
+}
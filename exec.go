@@ -0,0 +1,98 @@
+/*
+ * COPYRIGHT 2020 Brightgate Inc.  All rights reserved.
+ *
+ * This copyright notice is Copyright Management Information under 17 USC 1202
+ * and is included to protect this work and deter copyright infringement.
+ * Removal or alteration of this Copyright Management Information without the
+ * express written permission of Brightgate Inc is prohibited, and any
+ * such unauthorized removal or alteration will be a violation of federal law.
+ */
+
+package briefpg
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ExecOptions controls how ExecSQL and ExecFile run SQL.
+type ExecOptions struct {
+	// SingleTransaction runs all of the SQL in one transaction, so that
+	// either all of it takes effect or none of it does.
+	SingleTransaction bool
+}
+
+// SQLError is returned by ExecSQL and ExecFile when a statement fails.
+type SQLError struct {
+	File    string // File being run; "<stdin>" for ExecSQL
+	Line    int    // Input line at which the failing statement ended
+	Message string // Server's error message
+	Output  string // Complete output from psql
+}
+
+func (e *SQLError) Error() string {
+	return fmt.Sprintf("%s:%d: %s", e.File, e.Line, e.Message)
+}
+
+// psqlErrorRE matches psql's report of a failed statement, for example:
+//
+//	psql:schema.sql:12: ERROR:  relation "foo" does not exist
+var psqlErrorRE = regexp.MustCompile(
+	`(?m)^psql:(.*?):(\d+): (?:ERROR|FATAL|PANIC):\s+(.*)$`)
+
+// ExecSQL runs one or more SQL statements against the named database using
+// psql, stopping at the first error.  psql meta-commands such as \set may be
+// used.  If a statement fails, the error is a *SQLError.
+func (bp *BriefPG) ExecSQL(ctx context.Context, dbName, sql string, eo ExecOptions) error {
+	if bp.state < stateServerStarted {
+		return fmt.Errorf("Server not started; cannot execute SQL")
+	}
+	return bp.execPsql(ctx, "ExecSQL", dbName, "-", strings.NewReader(sql), eo)
+}
+
+// ExecFile runs the SQL in the named file against the named database, as
+// ExecSQL does.  Files included with \ir are found relative to path.
+func (bp *BriefPG) ExecFile(ctx context.Context, dbName, path string, eo ExecOptions) error {
+	if bp.state < stateServerStarted {
+		return fmt.Errorf("Server not started; cannot execute SQL file")
+	}
+	return bp.execPsql(ctx, "ExecFile", dbName, path, nil, eo)
+}
+
+// execPsql runs psql on the given file ("-" for stdin), translating errors
+// reported by the server into a *SQLError.
+func (bp *BriefPG) execPsql(ctx context.Context, what, dbName, file string,
+	stdin io.Reader, eo ExecOptions) error {
+
+	args := []string{"-X", "-q", "-v", "ON_ERROR_STOP=1"}
+	if eo.SingleTransaction {
+		args = append(args, "--single-transaction")
+	}
+	args = append(args, "-f", file, bp.DBUri(dbName))
+	cmd := exec.CommandContext(ctx, bp.pgCmds["psql"], args...)
+	cmd.Stdin = stdin
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	bp.logf("briefpg: %s\n", strings.Join(cmd.Args, " "))
+	err := cmd.Run()
+	out := strings.TrimSpace(output.String())
+	for _, line := range strings.Split(out, "\n") {
+		bp.logf("briefpg: %s\n", line)
+	}
+	if err == nil {
+		return nil
+	}
+	m := psqlErrorRE.FindStringSubmatch(out)
+	if m == nil || ctx.Err() != nil {
+		return wrapOutputErr(ctx, what+" failed", cmd, out, err)
+	}
+	line, _ := strconv.Atoi(m[2])
+	return &SQLError{File: m[1], Line: line, Message: m[3], Output: out}
+}
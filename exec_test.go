@@ -0,0 +1,121 @@
+/*
+ * COPYRIGHT 2020 Brightgate Inc.  All rights reserved.
+ *
+ * This copyright notice is Copyright Management Information under 17 USC 1202
+ * and is included to protect this work and deter copyright infringement.
+ * Removal or alteration of this Copyright Management Information without the
+ * express written permission of Brightgate Inc is prohibited, and any
+ * such unauthorized removal or alteration will be a violation of federal law.
+ */
+
+package briefpg
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExecSQL(t *testing.T) {
+	ctx := context.Background()
+	bpg, err := New(OptLogFunc(t.Logf))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if err = bpg.ExecSQL(ctx, "postgres", "SELECT 1", ExecOptions{}); err == nil {
+		t.Fatalf("Expected ExecSQL to fail")
+	}
+	if err = bpg.Start(ctx); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer bpg.MustFini(ctx)
+	if _, err = bpg.CreateDB(ctx, "test_db", ""); err != nil {
+		t.Fatalf("CreateDB failed: %v", err)
+	}
+
+	tableExists := func(name string) bool {
+		rows, err := bpg.psqlRows(ctx, "test", "test_db",
+			"SELECT 1 FROM pg_tables WHERE tablename = "+quoteLiteral(name))
+		if err != nil {
+			t.Fatalf("psqlRows failed: %v", err)
+		}
+		return len(rows) > 0
+	}
+
+	err = bpg.ExecSQL(ctx, "test_db", "CREATE TABLE a (i int);\n"+
+		"INSERT INTO a VALUES (1);\n", ExecOptions{})
+	if err != nil {
+		t.Fatalf("ExecSQL failed: %v", err)
+	}
+	if !tableExists("a") {
+		t.Fatalf("Expected table a to exist")
+	}
+
+	bad := "CREATE TABLE b (i int);\n" +
+		"INSERT INTO b\n" +
+		"  SELECT * FROM missing;\n" +
+		"CREATE TABLE c (i int);\n"
+	for _, single := range []bool{true, false} {
+		err = bpg.ExecSQL(ctx, "test_db", bad, ExecOptions{SingleTransaction: single})
+		var sqlErr *SQLError
+		if !errors.As(err, &sqlErr) {
+			t.Fatalf("Expected SQLError; got %v", err)
+		}
+		if sqlErr.File != "<stdin>" || sqlErr.Line != 3 ||
+			!strings.Contains(sqlErr.Message, "\"missing\" does not exist") {
+			t.Fatalf("Unexpected SQLError %v", sqlErr)
+		}
+		if tableExists("b") == single {
+			t.Fatalf("Unexpected table b state with SingleTransaction=%v", single)
+		}
+		if tableExists("c") {
+			t.Fatalf("Expected execution to stop at error")
+		}
+	}
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "schema.sql")
+	err = ioutil.WriteFile(path, []byte("\\ir more.sql\nCREATE TABLE d (i int);\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(filepath.Join(dir, "more.sql"), []byte("SELECT 1;\nSELEKT;\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = bpg.ExecFile(ctx, "test_db", path, ExecOptions{})
+	var sqlErr *SQLError
+	if !errors.As(err, &sqlErr) {
+		t.Fatalf("Expected SQLError; got %v", err)
+	}
+	if !strings.HasSuffix(sqlErr.File, "more.sql") || sqlErr.Line != 2 {
+		t.Fatalf("Unexpected SQLError %v", sqlErr)
+	}
+	err = ioutil.WriteFile(filepath.Join(dir, "more.sql"), []byte("SELECT 1;\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = bpg.ExecFile(ctx, "test_db", path, ExecOptions{}); err != nil {
+		t.Fatalf("ExecFile failed: %v", err)
+	}
+	if !tableExists("d") {
+		t.Fatalf("Expected table d to exist")
+	}
+	if err = bpg.ExecFile(ctx, "test_db", filepath.Join(dir, "none.sql"), ExecOptions{}); err == nil {
+		t.Fatalf("Expected ExecFile of missing file to fail")
+	}
+}
+
+func TestPsqlErrorRE(t *testing.T) {
+	out := "NOTICE:  something\n" +
+		"psql:/tmp/x:y.sql:12: ERROR:  relation \"foo\" does not exist\n" +
+		"LINE 1: SELECT * FROM foo;\n"
+	m := psqlErrorRE.FindStringSubmatch(out)
+	if m == nil || m[1] != "/tmp/x:y.sql" || m[2] != "12" ||
+		m[3] != "relation \"foo\" does not exist" {
+		t.Fatalf("Unexpected match %q", m)
+	}
+}
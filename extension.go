@@ -0,0 +1,107 @@
+/*
+ * COPYRIGHT 2020 Brightgate Inc.  All rights reserved.
+ *
+ * This copyright notice is Copyright Management Information under 17 USC 1202
+ * and is included to protect this work and deter copyright infringement.
+ * Removal or alteration of this Copyright Management Information without the
+ * express written permission of Brightgate Inc is prohibited, and any
+ * such unauthorized removal or alteration will be a violation of federal law.
+ */
+
+package briefpg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrExtensionNotAvailable is returned (wrapped) when a required extension is
+// not installed on the server's host.
+var ErrExtensionNotAvailable = errors.New("extension not available")
+
+// Extension describes an extension which is available to the server, whether
+// or not it has been created in any database.
+type Extension struct {
+	Name           string // Extension name, as used by CREATE EXTENSION
+	DefaultVersion string // Version created by default
+	Comment        string // Description of the extension
+}
+
+// AvailableExtensions returns the extensions installed on the server's host,
+// as listed by pg_available_extensions.
+func (bp *BriefPG) AvailableExtensions(ctx context.Context) ([]Extension, error) {
+	if bp.state < stateServerStarted {
+		return nil, fmt.Errorf("Server not started; cannot list extensions")
+	}
+	rows, err := bp.psqlRows(ctx, "AvailableExtensions", "postgres",
+		"SELECT name, coalesce(default_version, ''), coalesce(comment, '') "+
+			"FROM pg_available_extensions ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	exts := make([]Extension, 0, len(rows))
+	for _, row := range rows {
+		if len(row) != 3 {
+			return nil, fmt.Errorf("AvailableExtensions: unexpected row %q", row)
+		}
+		exts = append(exts, Extension{
+			Name:           row[0],
+			DefaultVersion: row[1],
+			Comment:        row[2],
+		})
+	}
+	return exts, nil
+}
+
+// missingExtensions returns those of names which are not available.
+func (bp *BriefPG) missingExtensions(ctx context.Context, names []string) ([]string, error) {
+	exts, err := bp.AvailableExtensions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	available := make(map[string]bool, len(exts))
+	for _, ext := range exts {
+		available[ext.Name] = true
+	}
+	var missing []string
+	for _, name := range names {
+		if !available[name] {
+			missing = append(missing, name)
+		}
+	}
+	return missing, nil
+}
+
+// checkExtensions returns an error wrapping ErrExtensionNotAvailable if any
+// of the extensions given with OptExtensions are not available.
+func (bp *BriefPG) checkExtensions(ctx context.Context) error {
+	if len(bp.extensions) == 0 {
+		return nil
+	}
+	missing, err := bp.missingExtensions(ctx, bp.extensions)
+	if err != nil {
+		return err
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%w: %s", ErrExtensionNotAvailable,
+			strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// createExtensions creates the extensions given with OptExtensions (and any
+// they depend upon) in dbName.
+func (bp *BriefPG) createExtensions(ctx context.Context, dbName string) error {
+	if len(bp.extensions) == 0 {
+		return nil
+	}
+	stmts := make([]string, 0, len(bp.extensions))
+	for _, ext := range bp.extensions {
+		stmts = append(stmts, fmt.Sprintf("CREATE EXTENSION IF NOT EXISTS %s CASCADE",
+			quoteIdent(ext)))
+	}
+	_, err := bp.psql(ctx, "Creating extensions", dbName, strings.Join(stmts, "; "))
+	return err
+}
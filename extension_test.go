@@ -0,0 +1,71 @@
+/*
+ * COPYRIGHT 2020 Brightgate Inc.  All rights reserved.
+ *
+ * This copyright notice is Copyright Management Information under 17 USC 1202
+ * and is included to protect this work and deter copyright infringement.
+ * Removal or alteration of this Copyright Management Information without the
+ * express written permission of Brightgate Inc is prohibited, and any
+ * such unauthorized removal or alteration will be a violation of federal law.
+ */
+
+package briefpg
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestExtensions(t *testing.T) {
+	ctx := context.Background()
+	bpg, err := New(OptLogFunc(t.Logf))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer bpg.MustFini(ctx)
+
+	if _, err = bpg.AvailableExtensions(ctx); err == nil {
+		t.Fatalf("Expected AvailableExtensions to fail")
+	}
+	if err = bpg.Start(ctx); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+
+	exts, err := bpg.AvailableExtensions(ctx)
+	if err != nil {
+		t.Fatalf("AvailableExtensions failed: %v", err)
+	}
+	found := false
+	for _, ext := range exts {
+		if ext.Name == "plpgsql" {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("Expected plpgsql to be available: %v", exts)
+	}
+
+	// plpgsql is always present, and already created.
+	if err = bpg.SetOption(OptExtensions("plpgsql")); err != nil {
+		t.Fatalf("SetOption failed: %v", err)
+	}
+	if _, err = bpg.CreateDB(ctx, "test_db", ""); err != nil {
+		t.Fatalf("CreateDB failed: %v", err)
+	}
+
+	if err = bpg.SetOption(OptExtensions("bogus_extension")); err != nil {
+		t.Fatalf("SetOption failed: %v", err)
+	}
+	_, err = bpg.CreateDB(ctx, "test_db2", "")
+	if err == nil || !errors.Is(err, ErrExtensionNotAvailable) {
+		t.Fatalf("Expected CreateDB to fail with ErrExtensionNotAvailable: %v", err)
+	}
+
+	t.Run("skip", func(t *testing.T) {
+		bpg.RequireExtensions(t, "plpgsql", "bogus_extension")
+		t.Fatalf("Expected RequireExtensions to skip")
+	})
+	t.Run("noskip", func(t *testing.T) {
+		bpg.RequireExtensions(t, "plpgsql")
+	})
+}
@@ -0,0 +1,24 @@
+//go:build windows || plan9
+// +build windows plan9
+
+/*
+ * COPYRIGHT 2020 Brightgate Inc.  All rights reserved.
+ *
+ * This copyright notice is Copyright Management Information under 17 USC 1202
+ * and is included to protect this work and deter copyright infringement.
+ * Removal or alteration of this Copyright Management Information without the
+ * express written permission of Brightgate Inc is prohibited, and any
+ * such unauthorized removal or alteration will be a violation of federal law.
+ */
+
+package briefpg
+
+import (
+	"errors"
+	"os"
+)
+
+// tryLockFile is not supported on this platform, so neither is OptShared.
+func tryLockFile(f *os.File) error {
+	return errors.New("file locking not supported")
+}
@@ -67,3 +67,149 @@ func OptPostgresEncoding(enc string) Option {
 		return bpg.setPostgresEncoding(enc)
 	})
 }
+
+// OptListenTCP returns an Option which causes the server to listen for TCP
+// connections on 127.0.0.1, in addition to its Unix-domain socket.  A free
+// port is chosen automatically when the server starts; if another process
+// claims the port first, Start() retries with a new one.  Use Port() to learn
+// the port; DBUri() returns URIs of the host:port form.  This option can only
+// be set before calling Start().
+func OptListenTCP() Option {
+	return optionFunc(func(bpg *BriefPG) error {
+		return bpg.setListenTCP()
+	})
+}
+
+// OptInitDBCache returns an Option which enables an on-disk cache of
+// initialized database clusters in dir.  Running initdb is usually the most
+// expensive part of Start(); with the cache enabled, the first instance runs
+// initdb and saves the result, and later instances (in this or other
+// processes) copy the saved cluster instead.  Cache entries are keyed by the
+// Postgres version, the initdb binary, the encoding, the locale environment
+// and the initdb arguments.  If dir is "", a "briefpg" directory under the
+// user's cache directory (see os.UserCacheDir) is used.  This option can only
+// be set before calling Start().
+func OptInitDBCache(dir string) Option {
+	return optionFunc(func(bpg *BriefPG) error {
+		return bpg.setInitDBCache(dir)
+	})
+}
+
+// skipOption is recognized by NewT and Main; it has no effect on the BriefPG itself.
+type skipOption struct{}
+
+func (skipOption) apply(bpg *BriefPG) error {
+	return nil
+}
+
+// OptSkipIfNotInstalled returns an Option which causes NewT to skip the test,
+// rather than failing it, if PostgreSQL can't be found; passed to Main, it
+// causes InstanceT to skip each test.  It has no effect when passed to New()
+// or SetOption().
+func OptSkipIfNotInstalled() Option {
+	return skipOption{}
+}
+
+// OptShared returns an Option which shares one server among every BriefPG
+// which uses the same directory, including those in other processes, such as
+// the test binaries run in parallel by "go test ./...".  The first to call
+// Start() makes and starts the server; later ones attach to it, and should
+// create their own databases in it (see CreateTestDB).  The server is left
+// running until the last of them calls Fini().  If dir is "", a
+// "briefpg.shared" directory under os.TempDir() is used.
+//
+// The participants coordinate through a lock file and a list of their PIDs in
+// dir.  The entries of participants which exit without calling Fini() are
+// discarded; if none remain, the server is stopped by the next participant to
+// leave, or by Reap.  Options which affect the server only take effect in the
+// participant which starts it, so all participants should use the same ones.
+// A shared server can't be stopped or restarted, and doesn't use a watchdog;
+// OptTLS can't be used with it.  This option can only be set before calling
+// Start(), and isn't supported on Windows.
+func OptShared(dir string) Option {
+	return optionFunc(func(bpg *BriefPG) error {
+		return bpg.setShared(dir)
+	})
+}
+
+// OptWatchdog returns an Option which starts a small supervisor process
+// alongside the server.  If this process exits without calling Fini() (for
+// example, because a test panicked or was killed), the supervisor stops the
+// server, so that it isn't left running.  The temporary directory is not
+// removed; see Reap for that.  This option requires /bin/sh, and can only be
+// set before calling Start().
+func OptWatchdog() Option {
+	return optionFunc(func(bpg *BriefPG) error {
+		return bpg.setWatchdog()
+	})
+}
+
+// OptSetting returns an Option which sets a Postgres configuration parameter,
+// such as "work_mem" or "log_statement".  Settings are written to
+// postgresql.conf after the contents of the config template, so they override
+// the defaults; values are quoted as needed.  If the server is already
+// running, use Reload() or Restart() (as the parameter requires) to apply the
+// change.  Use Settings() to read back the values in effect.  The parameters
+// which briefpg controls itself (listen_addresses, port and lc_messages) can't
+// be set.
+func OptSetting(name, value string) Option {
+	return OptSettings(map[string]string{name: value})
+}
+
+// OptSettings returns an Option which sets several Postgres configuration
+// parameters at once; see OptSetting.
+func OptSettings(settings map[string]string) Option {
+	return optionFunc(func(bpg *BriefPG) error {
+		return bpg.setSettings(settings)
+	})
+}
+
+// OptAuth returns an Option which selects the authentication method clients
+// must use.  For methods other than AuthTrust, the superuser's password is set
+// to password, or to a randomly generated one if password is "", and
+// pg_hba.conf is written to require the method for all connections.  DBUri()
+// includes the password; use Password() to retrieve it.  This option can only
+// be set before calling Start().
+func OptAuth(method AuthMethod, password string) Option {
+	return optionFunc(func(bpg *BriefPG) error {
+		return bpg.setAuth(method, password)
+	})
+}
+
+// OptTLS returns an Option which enables TLS.  A throwaway CA, and server and
+// client certificates signed by it, are generated in the temporary directory
+// when the server is initialized, and the server is configured to use them.
+// OptTLS implies OptListenTCP.  Use TLSFiles() to find the certificates, and
+// DBUriTLS() for a URI which uses them.  This option can only be set before
+// calling Start().
+func OptTLS() Option {
+	return optionFunc(func(bpg *BriefPG) error {
+		return bpg.setTLS()
+	})
+}
+
+// OptPostgresVersion returns an Option which selects among the installed
+// versions of Postgres (see InstalledVersions).  The constraint is a
+// comma-separated list of comparisons such as "16", ">=14" or ">=13, <16";
+// the newest installation which satisfies all of them is used.  A version
+// with no operator matches any version which begins with it, so "16" matches
+// "16.2".  If no installation matches, returns an error.  This option can
+// only be set before calling Start().
+func OptPostgresVersion(constraint string) Option {
+	return optionFunc(func(bpg *BriefPG) error {
+		return bpg.setPostgresVersion(constraint)
+	})
+}
+
+// OptExtensions returns an Option which causes CreateDB to create the named
+// extensions (such as "pgcrypto" or "citext"), along with any extensions they
+// depend upon, in each new database.  If any of the extensions are not
+// installed, CreateDB returns an error wrapping ErrExtensionNotAvailable,
+// and CreateTestDB skips the test.  Extensions accumulate if this option is
+// given more than once.
+func OptExtensions(names ...string) Option {
+	return optionFunc(func(bpg *BriefPG) error {
+		bpg.extensions = append(bpg.extensions, names...)
+		return nil
+	})
+}
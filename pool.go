@@ -0,0 +1,230 @@
+/*
+ * COPYRIGHT 2020 Brightgate Inc.  All rights reserved.
+ *
+ * This copyright notice is Copyright Management Information under 17 USC 1202
+ * and is included to protect this work and deter copyright infringement.
+ * Removal or alteration of this Copyright Management Information without the
+ * express written permission of Brightgate Inc is prohibited, and any
+ * such unauthorized removal or alteration will be a violation of federal law.
+ */
+
+package briefpg
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// poolPrefix begins the names of the databases made for the pool.
+const poolPrefix = "briefpg_pool_"
+
+// dbPool is a set of databases cloned ahead of time from a template, and
+// handed out by AcquireDB.  A single goroutine creates and drops databases,
+// so that the pool doesn't compete with the tests for the server.
+type dbPool struct {
+	template string
+	ready    chan string   // Databases waiting to be acquired
+	wake     chan struct{} // Signals the filler that there is work
+	failed   chan struct{} // Closed when err is set
+	cancel   context.CancelFunc
+	done     chan struct{} // Closed when the filler exits
+
+	mu      sync.Mutex
+	next    int             // Suffix for the next database name
+	create  int             // Number of databases to create
+	release []string        // Released databases, to be dropped
+	inUse   map[string]bool // Databases acquired and not yet released
+	err     error           // First failure of the filler
+}
+
+// StartPool starts a background goroutine which clones size databases from
+// templateName (see MakeTemplate), to be handed out by AcquireDB.  Each
+// database released by ReleaseDB is dropped and replaced, so that the pool
+// is refilled while tests run.  AcquireDB and ReleaseDB may be called
+// concurrently, such as from parallel subtests; StartPool and StopPool may
+// not.
+func (bp *BriefPG) StartPool(ctx context.Context, templateName string, size int) error {
+	if bp.state < stateServerStarted {
+		return fmt.Errorf("Server not started; cannot start pool")
+	}
+	if bp.pool != nil {
+		return fmt.Errorf("Database pool already started")
+	}
+	if size < 1 {
+		return fmt.Errorf("Invalid database pool size %d", size)
+	}
+	exists, err := bp.DatabaseExists(ctx, templateName)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("Database pool template %q does not exist",
+			templateName)
+	}
+
+	fillCtx, cancel := context.WithCancel(context.Background())
+	p := &dbPool{
+		template: templateName,
+		ready:    make(chan string, size),
+		wake:     make(chan struct{}, 1),
+		failed:   make(chan struct{}),
+		cancel:   cancel,
+		done:     make(chan struct{}),
+		create:   size,
+		inUse:    make(map[string]bool),
+	}
+	bp.pool = p
+	go bp.fillPool(fillCtx, p)
+	p.kick()
+	return nil
+}
+
+// AcquireDB returns the name of a database from the pool for the exclusive
+// use of the caller, waiting for one to be created if necessary.  Return the
+// database to the pool with ReleaseDB.
+func (bp *BriefPG) AcquireDB(ctx context.Context) (string, error) {
+	p := bp.pool
+	if p == nil {
+		return "", fmt.Errorf("Database pool not started; cannot acquire")
+	}
+	select {
+	case dbName := <-p.ready:
+		p.mu.Lock()
+		p.inUse[dbName] = true
+		p.mu.Unlock()
+		return dbName, nil
+	case <-p.failed:
+		return "", fmt.Errorf("Database pool failed: %w", p.failure())
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// ReleaseDB returns a database acquired by AcquireDB to the pool.  It does
+// not wait: the database is dropped and replaced in the background.
+func (bp *BriefPG) ReleaseDB(dbName string) error {
+	p := bp.pool
+	if p == nil {
+		return fmt.Errorf("Database pool not started; cannot release")
+	}
+	p.mu.Lock()
+	if !p.inUse[dbName] {
+		p.mu.Unlock()
+		return fmt.Errorf("Database %q was not acquired from the pool", dbName)
+	}
+	delete(p.inUse, dbName)
+	p.release = append(p.release, dbName)
+	p.create++
+	p.mu.Unlock()
+	p.kick()
+	return nil
+}
+
+// StopPool stops the pool's goroutine, and drops the databases which have
+// not been acquired.  Databases which are still acquired are left alone.
+// Fini stops the pool if needed, dropping its databases if the server is
+// shared (see OptShared).
+func (bp *BriefPG) StopPool(ctx context.Context) error {
+	p := bp.pool
+	if p == nil {
+		return nil
+	}
+	bp.stopPoolFiller()
+
+	// Drop every database made for the pool which isn't acquired, including
+	// any whose creation was interrupted.
+	for i := 1; i <= p.next; i++ {
+		dbName := poolDBName(i)
+		if p.inUse[dbName] {
+			continue
+		}
+		if err := bp.DropDB(ctx, dbName, true); err != nil {
+			return err
+		}
+	}
+	if err := p.failure(); err != nil {
+		return fmt.Errorf("Database pool failed: %w", err)
+	}
+	return nil
+}
+
+// stopPoolFiller stops the pool's goroutine, if any, and waits for it to
+// exit.
+func (bp *BriefPG) stopPoolFiller() {
+	if p := bp.pool; p != nil {
+		bp.pool = nil
+		p.cancel()
+		<-p.done
+	}
+}
+
+// fillPool runs in the background, dropping released databases and creating
+// new ones, until ctx is canceled.  If an operation fails, the pool stops
+// doing work, and the failure is reported by AcquireDB and StopPool.
+func (bp *BriefPG) fillPool(ctx context.Context, p *dbPool) {
+	defer close(p.done)
+	for {
+		p.mu.Lock()
+		var drop, create string
+		if p.err == nil {
+			if len(p.release) > 0 {
+				drop = p.release[0]
+				p.release = p.release[1:]
+			} else if p.create > 0 {
+				p.create--
+				p.next++
+				create = poolDBName(p.next)
+			}
+		}
+		p.mu.Unlock()
+
+		var err error
+		switch {
+		case drop != "":
+			err = bp.DropDB(ctx, drop, true)
+		case create != "":
+			if _, err = bp.CloneDB(ctx, p.template, create); err == nil {
+				p.ready <- create
+			}
+		default:
+			select {
+			case <-ctx.Done():
+				return
+			case <-p.wake:
+			}
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			bp.logf("briefpg: database pool failed: %v\n", err)
+			p.mu.Lock()
+			p.err = err
+			p.mu.Unlock()
+			close(p.failed)
+		}
+	}
+}
+
+// poolDBName returns the name of the i'th database made for the pool.  The
+// PID keeps the names distinct on a server shared with other processes.
+func poolDBName(i int) string {
+	return fmt.Sprintf("%s%d_%d", poolPrefix, os.Getpid(), i)
+}
+
+// kick wakes the filler goroutine, if it isn't already due to wake.
+func (p *dbPool) kick() {
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+// failure returns the error which stopped the filler, if any.
+func (p *dbPool) failure() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.err
+}
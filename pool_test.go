@@ -0,0 +1,108 @@
+/*
+ * COPYRIGHT 2020 Brightgate Inc.  All rights reserved.
+ *
+ * This copyright notice is Copyright Management Information under 17 USC 1202
+ * and is included to protect this work and deter copyright infringement.
+ * Removal or alteration of this Copyright Management Information without the
+ * express written permission of Brightgate Inc is prohibited, and any
+ * such unauthorized removal or alteration will be a violation of federal law.
+ */
+
+package briefpg
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestPool(t *testing.T) {
+	ctx := context.Background()
+	bpg, err := New(OptLogFunc(t.Logf))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if err = bpg.StartPool(ctx, "base_db", 2); err == nil {
+		t.Fatalf("Expected StartPool to fail")
+	}
+	if err = bpg.Start(ctx); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer bpg.MustFini(ctx)
+	if _, err = bpg.AcquireDB(ctx); err == nil {
+		t.Fatalf("Expected AcquireDB without pool to fail")
+	}
+	if err = bpg.StartPool(ctx, "base_db", 2); err == nil {
+		t.Fatalf("Expected StartPool without template to fail")
+	}
+
+	if _, err = bpg.CreateDB(ctx, "base_db", ""); err != nil {
+		t.Fatalf("CreateDB failed: %v", err)
+	}
+	err = bpg.ExecSQL(ctx, "base_db", "CREATE TABLE t (i int);", ExecOptions{})
+	if err != nil {
+		t.Fatalf("ExecSQL failed: %v", err)
+	}
+	if err = bpg.MakeTemplate(ctx, "base_db"); err != nil {
+		t.Fatalf("MakeTemplate failed: %v", err)
+	}
+	if err = bpg.StartPool(ctx, "base_db", 2); err != nil {
+		t.Fatalf("StartPool failed: %v", err)
+	}
+	if err = bpg.StartPool(ctx, "base_db", 2); err == nil {
+		t.Fatalf("Expected second StartPool to fail")
+	}
+	if err = bpg.ReleaseDB("base_db"); err == nil {
+		t.Fatalf("Expected ReleaseDB of unpooled database to fail")
+	}
+
+	// More parallel tests than databases, so that some must wait for
+	// replacements.
+	t.Run("group", func(t *testing.T) {
+		for i := 0; i < 6; i++ {
+			t.Run(fmt.Sprintf("sub%d", i), func(t *testing.T) {
+				t.Parallel()
+				uri := bpg.AcquireTestDB(t)
+				dbName := strings.TrimPrefix(uri, "postgresql:///")
+				dbName = dbName[:strings.IndexByte(dbName, '?')]
+				if !strings.HasPrefix(dbName, poolPrefix) {
+					t.Fatalf("Unexpected pooled database %q", dbName)
+				}
+				n, err := bpg.QueryInt(ctx, dbName, "SELECT count(*) FROM t")
+				if err != nil || n != 0 {
+					t.Fatalf("Expected fresh database; got %d, %v", n, err)
+				}
+				err = bpg.ExecSQL(ctx, dbName, "INSERT INTO t VALUES (1);",
+					ExecOptions{})
+				if err != nil {
+					t.Fatalf("ExecSQL failed: %v", err)
+				}
+			})
+		}
+	})
+
+	dbName, err := bpg.AcquireDB(ctx)
+	if err != nil {
+		t.Fatalf("AcquireDB failed: %v", err)
+	}
+	if err = bpg.ReleaseDB(dbName); err != nil {
+		t.Fatalf("ReleaseDB failed: %v", err)
+	}
+	if err = bpg.ReleaseDB(dbName); err == nil {
+		t.Fatalf("Expected second ReleaseDB to fail")
+	}
+	if err = bpg.StopPool(ctx); err != nil {
+		t.Fatalf("StopPool failed: %v", err)
+	}
+	names, err := bpg.ListDatabases(ctx)
+	if err != nil {
+		t.Fatalf("ListDatabases failed: %v", err)
+	}
+	for _, name := range names {
+		if strings.HasPrefix(name, poolPrefix) {
+			t.Errorf("Expected pooled databases to be dropped; found %s",
+				name)
+		}
+	}
+}
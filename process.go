@@ -0,0 +1,56 @@
+//go:build !plan9
+// +build !plan9
+
+/*
+ * COPYRIGHT 2020 Brightgate Inc.  All rights reserved.
+ *
+ * This copyright notice is Copyright Management Information under 17 USC 1202
+ * and is included to protect this work and deter copyright infringement.
+ * Removal or alteration of this Copyright Management Information without the
+ * express written permission of Brightgate Inc is prohibited, and any
+ * such unauthorized removal or alteration will be a violation of federal law.
+ */
+
+package briefpg
+
+import (
+	"context"
+	"errors"
+	"os"
+	"syscall"
+	"time"
+)
+
+// stopPostmaster performs an immediate shutdown of the postmaster pid, and
+// waits for it to exit.
+func stopPostmaster(ctx context.Context, pid int) error {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	if err := p.Signal(syscall.SIGQUIT); err != nil {
+		return err
+	}
+	for processAlive(pid) {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(reapPollInterval):
+		}
+	}
+	return nil
+}
+
+// processAlive reports whether the process pid exists.  If we can't tell,
+// it errs on the side of reporting that the process exists.
+func processAlive(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	err = p.Signal(syscall.Signal(0))
+	if err == nil {
+		return true
+	}
+	return !errors.Is(err, os.ErrProcessDone) && !errors.Is(err, syscall.ESRCH)
+}
@@ -0,0 +1,27 @@
+/*
+ * COPYRIGHT 2020 Brightgate Inc.  All rights reserved.
+ *
+ * This copyright notice is Copyright Management Information under 17 USC 1202
+ * and is included to protect this work and deter copyright infringement.
+ * Removal or alteration of this Copyright Management Information without the
+ * express written permission of Brightgate Inc is prohibited, and any
+ * such unauthorized removal or alteration will be a violation of federal law.
+ */
+
+package briefpg
+
+import (
+	"context"
+	"errors"
+)
+
+// stopPostmaster is not supported on this platform.
+func stopPostmaster(ctx context.Context, pid int) error {
+	return errors.New("stopping processes not supported")
+}
+
+// processAlive can't tell whether a process exists on this platform, so it
+// errs on the side of reporting that it does.
+func processAlive(pid int) bool {
+	return true
+}
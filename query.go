@@ -0,0 +1,281 @@
+/*
+ * COPYRIGHT 2020 Brightgate Inc.  All rights reserved.
+ *
+ * This copyright notice is Copyright Management Information under 17 USC 1202
+ * and is included to protect this work and deter copyright infringement.
+ * Removal or alteration of this Copyright Management Information without the
+ * express written permission of Brightgate Inc is prohibited, and any
+ * such unauthorized removal or alteration will be a violation of federal law.
+ */
+
+package briefpg
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Separators and NULL marker used to parse psql's unaligned output.  Control
+// characters are unlikely to appear in the data.
+const (
+	fieldSep   = "\x1f"
+	recordSep  = "\x1e"
+	nullMarker = "\x1a" + "NULL" + "\x1a"
+)
+
+// QueryResult holds the result of Query.  Values are formatted as by psql,
+// which uses the server's text representation of each type.
+type QueryResult struct {
+	Columns []string   // Column names
+	Rows    [][]string // Values; "" for NULL
+	Nulls   [][]bool   // Whether each value is NULL
+}
+
+// IsNull reports whether the value in the given row and column is NULL.
+func (qr *QueryResult) IsNull(row, col int) bool {
+	return qr.Nulls[row][col]
+}
+
+// Query runs a SQL query against the named database using psql, and returns
+// its result.  This is meant for checking a few values in a test, not as a
+// substitute for a database driver.
+//
+// Placeholders $1, $2 etc. in the query are replaced by the corresponding
+// argument, formatted as a SQL literal.  Arguments may be nil (NULL), strings,
+// []byte (bytea), booleans, integers, floats, time.Time, or any value which
+// implements fmt.Stringer; negative numbers are parenthesized, so that "1-$1"
+// works as expected.  Placeholders inside quoted strings (including E'...'
+// escape strings), quoted identifiers, comments and dollar-quoted bodies are
+// left alone.
+func (bp *BriefPG) Query(ctx context.Context, dbName, query string, args ...interface{}) (*QueryResult, error) {
+	if bp.state < stateServerStarted {
+		return nil, fmt.Errorf("Server not started; cannot query")
+	}
+	query, err := substituteArgs(query, args)
+	if err != nil {
+		return nil, fmt.Errorf("Query failed: %w", err)
+	}
+	return bp.query(ctx, "Query", dbName, query)
+}
+
+// QueryString runs a query which returns a single non-NULL value, such as
+// "SELECT name FROM t WHERE id = $1", and returns that value.
+func (bp *BriefPG) QueryString(ctx context.Context, dbName, query string, args ...interface{}) (string, error) {
+	qr, err := bp.Query(ctx, dbName, query, args...)
+	if err != nil {
+		return "", err
+	}
+	if len(qr.Rows) != 1 || len(qr.Columns) != 1 {
+		return "", fmt.Errorf("Query returned %d rows of %d columns; "+
+			"expected a single value", len(qr.Rows), len(qr.Columns))
+	}
+	if qr.IsNull(0, 0) {
+		return "", fmt.Errorf("Query returned NULL")
+	}
+	return qr.Rows[0][0], nil
+}
+
+// QueryInt runs a query which returns a single non-NULL integer, such as
+// "SELECT count(*) FROM t", and returns that integer.
+func (bp *BriefPG) QueryInt(ctx context.Context, dbName, query string, args ...interface{}) (int64, error) {
+	s, err := bp.QueryString(ctx, dbName, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("Query returned non-integer: %w", err)
+	}
+	return n, nil
+}
+
+// query runs a query against dbName using psql's unaligned output, returning
+// the result; what describes the operation for error messages.
+func (bp *BriefPG) query(ctx context.Context, what, dbName, query string) (*QueryResult, error) {
+	cmd := exec.CommandContext(ctx, bp.pgCmds["psql"], "-X", "-q", "-A",
+		"-P", "footer=off", "-P", "null="+nullMarker,
+		"-v", "ON_ERROR_STOP=1", "-F", fieldSep, "-R", recordSep,
+		"-c", query, bp.DBUri(dbName))
+	bp.logf("briefpg: %s\n", strings.Join(cmd.Args, " "))
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		out := strings.TrimSpace(stderr.String())
+		bp.logf("briefpg: %s\n", out)
+		return nil, wrapOutputErr(ctx, what+" failed", cmd, out, err)
+	}
+	return parseUnaligned(stdout.String()), nil
+}
+
+// parseUnaligned parses psql's unaligned output, with a header record, into a
+// QueryResult.
+func parseUnaligned(out string) *QueryResult {
+	qr := &QueryResult{}
+	// The last record is terminated by a newline, not the record separator.
+	out = strings.TrimSuffix(out, "\n")
+	if out == "" {
+		return qr
+	}
+	records := strings.Split(out, recordSep)
+	qr.Columns = strings.Split(records[0], fieldSep)
+	for _, rec := range records[1:] {
+		row := strings.Split(rec, fieldSep)
+		nulls := make([]bool, len(row))
+		for i := range row {
+			if row[i] == nullMarker {
+				row[i] = ""
+				nulls[i] = true
+			}
+		}
+		qr.Rows = append(qr.Rows, row)
+		qr.Nulls = append(qr.Nulls, nulls)
+	}
+	return qr
+}
+
+// substituteArgs replaces the placeholders $1, $2 etc. in query with SQL
+// literals for the corresponding arguments.  It understands enough SQL
+// lexical structure to leave strings, identifiers and comments alone.
+func substituteArgs(query string, args []interface{}) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(query); {
+		rest := query[i:]
+		var skip int
+		switch {
+		case (rest[0] == 'E' || rest[0] == 'e') && len(rest) > 1 && rest[1] == '\'':
+			skip = escapeString(rest)
+		case rest[0] == '\'' || rest[0] == '"':
+			// Quotes are escaped by doubling, which this handles as
+			// two adjacent quoted strings.
+			if end := strings.IndexByte(rest[1:], rest[0]); end >= 0 {
+				skip = end + 2
+			} else {
+				skip = len(rest)
+			}
+		case strings.HasPrefix(rest, "--"):
+			if end := strings.IndexByte(rest, '\n'); end >= 0 {
+				skip = end + 1
+			} else {
+				skip = len(rest)
+			}
+		case strings.HasPrefix(rest, "/*"):
+			if end := strings.Index(rest[2:], "*/"); end >= 0 {
+				skip = end + 4
+			} else {
+				skip = len(rest)
+			}
+		case rest[0] == '$' && len(rest) > 1 && rest[1] >= '0' && rest[1] <= '9':
+			end := 1
+			for end < len(rest) && rest[end] >= '0' && rest[end] <= '9' {
+				end++
+			}
+			n, _ := strconv.Atoi(rest[1:end])
+			if n < 1 || n > len(args) {
+				return "", fmt.Errorf("no argument for placeholder %s",
+					rest[:end])
+			}
+			lit, err := sqlLiteral(args[n-1])
+			if err != nil {
+				return "", fmt.Errorf("placeholder %s: %w", rest[:end], err)
+			}
+			b.WriteString(lit)
+			i += end
+			continue
+		case rest[0] == '$':
+			skip = dollarQuoted(rest)
+		default:
+			// Skip identifiers whole, so that "a$1" isn't a placeholder.
+			skip = 1
+			for skip < len(rest) && isIdentChar(rest[skip]) && isIdentChar(rest[0]) {
+				skip++
+			}
+		}
+		b.WriteString(rest[:skip])
+		i += skip
+	}
+	return b.String(), nil
+}
+
+// escapeString returns the length of the escape string (E'...') at the start
+// of s, in which quotes may be escaped with a backslash as well as doubled.
+func escapeString(s string) int {
+	for i := 2; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '\'':
+			if i+1 < len(s) && s[i+1] == '\'' {
+				i++
+				continue
+			}
+			return i + 1
+		}
+	}
+	return len(s)
+}
+
+// dollarQuoted returns the length of the dollar-quoted string ($tag$...$tag$)
+// at the start of s, or 1 if s doesn't start with one.
+func dollarQuoted(s string) int {
+	end := 1
+	for end < len(s) && s[end] != '$' && isIdentChar(s[end]) {
+		end++
+	}
+	if end >= len(s) || s[end] != '$' {
+		return 1
+	}
+	tag := s[:end+1]
+	if close := strings.Index(s[len(tag):], tag); close >= 0 {
+		return len(tag) + close + len(tag)
+	}
+	return len(s)
+}
+
+func isIdentChar(c byte) bool {
+	return c == '_' || c == '$' || c >= 0x80 ||
+		(c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+// sqlLiteral formats a Go value as a SQL literal.
+func sqlLiteral(arg interface{}) (string, error) {
+	switch v := arg.(type) {
+	case nil:
+		return "NULL", nil
+	case string:
+		return quoteLiteral(v), nil
+	case []byte:
+		return quoteLiteral(`\x`+hex.EncodeToString(v)) + "::bytea", nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		// Parenthesize negative numbers, so that "1-$1" doesn't
+		// become a comment.
+		lit := fmt.Sprint(v)
+		if strings.HasPrefix(lit, "-") {
+			lit = "(" + lit + ")"
+		}
+		return lit, nil
+	case float32:
+		return floatLiteral(float64(v), 32), nil
+	case float64:
+		return floatLiteral(v, 64), nil
+	case time.Time:
+		return quoteLiteral(v.Format(time.RFC3339Nano)) + "::timestamptz", nil
+	case fmt.Stringer:
+		return quoteLiteral(v.String()), nil
+	}
+	return "", fmt.Errorf("unsupported argument type %T", arg)
+}
+
+// floatLiteral formats a float, quoting it so that special values such as
+// NaN and Infinity are accepted.
+func floatLiteral(f float64, bits int) string {
+	return quoteLiteral(strconv.FormatFloat(f, 'g', -1, bits)) + "::float8"
+}
@@ -0,0 +1,133 @@
+/*
+ * COPYRIGHT 2020 Brightgate Inc.  All rights reserved.
+ *
+ * This copyright notice is Copyright Management Information under 17 USC 1202
+ * and is included to protect this work and deter copyright infringement.
+ * Removal or alteration of this Copyright Management Information without the
+ * express written permission of Brightgate Inc is prohibited, and any
+ * such unauthorized removal or alteration will be a violation of federal law.
+ */
+
+package briefpg
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestSubstituteArgs(t *testing.T) {
+	when := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		query string
+		args  []interface{}
+		want  string
+	}{
+		{"SELECT $1, $2", []interface{}{"it's", 42}, "SELECT 'it''s', 42"},
+		{"SELECT $1,$1", []interface{}{nil}, "SELECT NULL,NULL"},
+		{"SELECT '$1', \"$1\", $1", []interface{}{true}, "SELECT '$1', \"$1\", true"},
+		{"SELECT 'a''$1', $1", []interface{}{false}, "SELECT 'a''$1', false"},
+		{"SELECT 1-$1, -$2", []interface{}{-2, 5}, "SELECT 1-(-2), -5"},
+		{"SELECT -$1", []interface{}{-5}, "SELECT -(-5)"},
+		{`SELECT E'it\'s $1', $1`, []interface{}{1}, `SELECT E'it\'s $1', 1`},
+		{`SELECT e'a\\', $1`, []interface{}{1}, `SELECT e'a\\', 1`},
+		{`SELECT E'a''$1', $1`, []interface{}{1}, `SELECT E'a''$1', 1`},
+		{`SELECT some'$1', $1`, []interface{}{1}, `SELECT some'$1', 1`},
+		{"SELECT 1 -- $1\n, $1", []interface{}{1}, "SELECT 1 -- $1\n, 1"},
+		{"SELECT /* $1 */ $1", []interface{}{1}, "SELECT /* $1 */ 1"},
+		{"SELECT $$$1$$, $1", []interface{}{1}, "SELECT $$$1$$, 1"},
+		{"SELECT $f$ $1 $f$, $1", []interface{}{1}, "SELECT $f$ $1 $f$, 1"},
+		{"SELECT a$1 FROM t", nil, "SELECT a$1 FROM t"},
+		{"SELECT $1", []interface{}{[]byte{0xde, 0xad}}, "SELECT '\\xdead'::bytea"},
+		{"SELECT $1", []interface{}{1.5}, "SELECT '1.5'::float8"},
+		{"SELECT $1", []interface{}{math.Inf(-1)}, "SELECT '-Inf'::float8"},
+		{"SELECT $1", []interface{}{when}, "SELECT '2020-01-02T03:04:05Z'::timestamptz"},
+		{"SELECT $1", []interface{}{Version{Major: 13, Minor: 2}}, "SELECT '13.2'"},
+	}
+	for _, tc := range tests {
+		got, err := substituteArgs(tc.query, tc.args)
+		if err != nil {
+			t.Fatalf("substituteArgs(%q) failed: %v", tc.query, err)
+		}
+		if got != tc.want {
+			t.Errorf("substituteArgs(%q) = %q; wanted %q", tc.query, got, tc.want)
+		}
+	}
+	for _, query := range []string{"SELECT $2", "SELECT $0"} {
+		if _, err := substituteArgs(query, []interface{}{1}); err == nil {
+			t.Errorf("Expected substituteArgs(%q) to fail", query)
+		}
+	}
+	if _, err := substituteArgs("SELECT $1", []interface{}{struct{}{}}); err == nil {
+		t.Errorf("Expected unsupported type to fail")
+	}
+}
+
+func TestParseUnaligned(t *testing.T) {
+	qr := parseUnaligned("a" + fieldSep + "b" + recordSep +
+		"1" + fieldSep + nullMarker + recordSep +
+		"" + fieldSep + "x\n" + "\n")
+	if len(qr.Columns) != 2 || qr.Columns[1] != "b" || len(qr.Rows) != 2 {
+		t.Fatalf("Unexpected result %+v", qr)
+	}
+	if !qr.IsNull(0, 1) || qr.IsNull(1, 0) || qr.Rows[1][1] != "x\n" {
+		t.Fatalf("Unexpected result %+v", qr)
+	}
+	if qr = parseUnaligned(""); len(qr.Columns) != 0 || len(qr.Rows) != 0 {
+		t.Fatalf("Unexpected result %+v", qr)
+	}
+}
+
+func TestQuery(t *testing.T) {
+	ctx := context.Background()
+	bpg, err := New(OptLogFunc(t.Logf))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if _, err = bpg.Query(ctx, "postgres", "SELECT 1"); err == nil {
+		t.Fatalf("Expected Query to fail")
+	}
+	if err = bpg.Start(ctx); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer bpg.MustFini(ctx)
+
+	qr, err := bpg.Query(ctx, "postgres",
+		"SELECT $1::text AS a, NULL AS b, '' AS c UNION ALL SELECT 'x', 'y', 'z'", "it's")
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(qr.Columns) != 3 || qr.Columns[0] != "a" || len(qr.Rows) != 2 {
+		t.Fatalf("Unexpected result %+v", qr)
+	}
+	if qr.Rows[0][0] != "it's" || !qr.IsNull(0, 1) || qr.IsNull(0, 2) ||
+		qr.Rows[1][2] != "z" {
+		t.Fatalf("Unexpected result %+v", qr)
+	}
+
+	qr, err = bpg.Query(ctx, "postgres", "SELECT 1 WHERE false")
+	if err != nil || len(qr.Columns) != 1 || len(qr.Rows) != 0 {
+		t.Fatalf("Unexpected result %+v, %v", qr, err)
+	}
+
+	n, err := bpg.QueryInt(ctx, "postgres", "SELECT $1 + $2", 40, 2)
+	if err != nil || n != 42 {
+		t.Fatalf("QueryInt returned %d, %v", n, err)
+	}
+	s, err := bpg.QueryString(ctx, "postgres", "SELECT current_database()")
+	if err != nil || s != "postgres" {
+		t.Fatalf("QueryString returned %q, %v", s, err)
+	}
+	for _, query := range []string{
+		"SELECT NULL",
+		"SELECT 1, 2",
+		"SELECT 1 WHERE false",
+		"SELECT 'x'",
+		"SELECT * FROM missing",
+	} {
+		if _, err = bpg.QueryInt(ctx, "postgres", query); err == nil {
+			t.Fatalf("Expected QueryInt(%q) to fail", query)
+		}
+	}
+}
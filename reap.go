@@ -0,0 +1,193 @@
+/*
+ * COPYRIGHT 2020 Brightgate Inc.  All rights reserved.
+ *
+ * This copyright notice is Copyright Management Information under 17 USC 1202
+ * and is included to protect this work and deter copyright infringement.
+ * Removal or alteration of this Copyright Management Information without the
+ * express written permission of Brightgate Inc is prohibited, and any
+ * such unauthorized removal or alteration will be a violation of federal law.
+ */
+
+package briefpg
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// ownerFile is written into automatically created temporary
+	// directories, and contains the PID of the process which made it.
+	ownerFile = "owner.pid"
+
+	// reapGracePeriod protects directories which lack an owner file from
+	// being reaped while their owner is still setting them up.
+	reapGracePeriod = time.Minute
+
+	// reapPollInterval is how often Reap checks whether a postmaster it
+	// signalled has exited.
+	reapPollInterval = 100 * time.Millisecond
+)
+
+// ReapOptions controls the behavior of Reap.
+type ReapOptions struct {
+	Dir    string      // Directory to search; defaults to os.TempDir()
+	DryRun bool        // Report what would be reaped, but change nothing
+	Logf   LogFunction // Verbose output; defaults to NullLogFunction
+}
+
+// Reap cleans up after BriefPG instances whose owning process exited without
+// calling Fini(), for example because a test binary panicked or was killed.
+// It looks for the temporary directories which BriefPG creates automatically
+// (see OptTmpDir), and the default shared directory (see OptShared), and for
+// each one whose owners are no longer running, stops any Postgres server
+// still running there and removes the directory.  The lock file and
+// participants list of a shared directory are kept.  The list of reaped
+// directories is returned.  Directories given explicitly with OptTmpDir or
+// OptShared are never reaped.
+func Reap(ctx context.Context, ro ReapOptions) ([]string, error) {
+	if ro.Dir == "" {
+		ro.Dir = os.TempDir()
+	}
+	if ro.Logf == nil {
+		ro.Logf = NullLogFunction
+	}
+
+	dirs, err := filepath.Glob(filepath.Join(ro.Dir, "briefpg.*"))
+	if err != nil {
+		return nil, fmt.Errorf("Reap failed: %w", err)
+	}
+
+	var reaped []string
+	var errs []string
+	for _, dir := range dirs {
+		if err := ctx.Err(); err != nil {
+			return reaped, fmt.Errorf("Reap interrupted: %w", err)
+		}
+		fi, err := os.Stat(dir)
+		if err != nil || !fi.IsDir() {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(dir, participantsDir)); err == nil {
+			ok, err := reapShared(ctx, dir, ro.DryRun, ro.Logf)
+			if err != nil {
+				errs = append(errs, err.Error())
+			} else if ok {
+				reaped = append(reaped, dir)
+			}
+			continue
+		}
+		if !orphaned(dir, fi, ro.Logf) {
+			continue
+		}
+		if ro.DryRun {
+			ro.Logf("briefpg: would reap %s\n", dir)
+			reaped = append(reaped, dir)
+			continue
+		}
+		if err := reapDir(ctx, dir, ro.Logf); err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		reaped = append(reaped, dir)
+	}
+
+	if len(errs) > 0 {
+		return reaped, fmt.Errorf("Reap failed: %s", strings.Join(errs, "; "))
+	}
+	return reaped, nil
+}
+
+// orphaned determines whether the BriefPG temporary directory dir has been
+// abandoned by its owner.
+func orphaned(dir string, fi os.FileInfo, logf LogFunction) bool {
+	pid, err := readPIDFile(filepath.Join(dir, ownerFile))
+	if err == nil {
+		if processAlive(pid) {
+			logf("briefpg: %s: owner %d is running\n", dir, pid)
+			return false
+		}
+		return true
+	}
+
+	// Without an owner, we can only be sure that an old directory with no
+	// running server is abandoned.
+	if time.Since(fi.ModTime()) < reapGracePeriod {
+		logf("briefpg: %s: no owner, but too new to reap\n", dir)
+		return false
+	}
+	for _, pm := range postmasters(dir) {
+		if processAlive(pm) {
+			logf("briefpg: %s: no owner, but server %d is running\n", dir, pm)
+			return false
+		}
+	}
+	return true
+}
+
+// reapDir stops any servers running in the abandoned directory dir, then
+// removes it.
+func reapDir(ctx context.Context, dir string, logf LogFunction) error {
+	for _, pm := range postmasters(dir) {
+		if !processAlive(pm) || !isPostmaster(pm) {
+			continue
+		}
+		logf("briefpg: %s: stopping server %d\n", dir, pm)
+		if err := stopPostmaster(ctx, pm); err != nil {
+			return fmt.Errorf("%s: failed to stop server %d: %w", dir, pm, err)
+		}
+	}
+	logf("briefpg: %s: removing\n", dir)
+	if err := os.RemoveAll(dir); err != nil {
+		return fmt.Errorf("%s: failed to remove: %w", dir, err)
+	}
+	return nil
+}
+
+// postmasters returns the PIDs recorded in the postmaster.pid files of any
+// clusters in the BriefPG temporary directory dir.  Shared directories (see
+// OptShared) hold a temporary directory for each version.
+func postmasters(dir string) []int {
+	var pids []int
+	pidFiles, _ := filepath.Glob(filepath.Join(dir, "*", "postmaster.pid"))
+	shared, _ := filepath.Glob(filepath.Join(dir, "*", "*", "postmaster.pid"))
+	pidFiles = append(pidFiles, shared...)
+	for _, pidFile := range pidFiles {
+		if pid, err := readPIDFile(pidFile); err == nil {
+			pids = append(pids, pid)
+		}
+	}
+	return pids
+}
+
+// readPIDFile returns the PID found on the first line of the named file.
+func readPIDFile(name string) (int, error) {
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		return 0, err
+	}
+	line := strings.SplitN(string(b), "\n", 2)[0]
+	pid, err := strconv.Atoi(strings.TrimSpace(line))
+	if err != nil || pid <= 0 {
+		return 0, fmt.Errorf("%s: bad pid %q", name, line)
+	}
+	return pid, nil
+}
+
+// isPostmaster guards against signalling an unrelated process which has
+// reused the PID of a postmaster which has since exited.  Where /proc is not
+// available, we have to assume the PID is still the postmaster's.
+func isPostmaster(pid int) bool {
+	cmdline, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/cmdline", pid))
+	if err != nil {
+		return true
+	}
+	return strings.Contains(string(cmdline), "postgres") ||
+		strings.Contains(string(cmdline), "postmaster")
+}
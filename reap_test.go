@@ -0,0 +1,127 @@
+/*
+ * COPYRIGHT 2020 Brightgate Inc.  All rights reserved.
+ *
+ * This copyright notice is Copyright Management Information under 17 USC 1202
+ * and is included to protect this work and deter copyright infringement.
+ * Removal or alteration of this Copyright Management Information without the
+ * express written permission of Brightgate Inc is prohibited, and any
+ * such unauthorized removal or alteration will be a violation of federal law.
+ */
+
+package briefpg
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+// deadPID returns the PID of a process which has exited.
+func deadPID(t *testing.T) int {
+	cmd := exec.Command("true")
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	return cmd.Process.Pid
+}
+
+func writeOwner(t *testing.T, dir string, pid int) {
+	err := ioutil.WriteFile(filepath.Join(dir, ownerFile),
+		[]byte(strconv.Itoa(pid)), 0600)
+	if err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+}
+
+func TestReapDirs(t *testing.T) {
+	ctx := context.Background()
+	parent, err := ioutil.TempDir("", "test.")
+	if err != nil {
+		t.Fatalf("TempDir failed: %v", err)
+	}
+	defer os.RemoveAll(parent)
+
+	dead := filepath.Join(parent, "briefpg.test.dead")
+	live := filepath.Join(parent, "briefpg.test.live")
+	young := filepath.Join(parent, "briefpg.test.young")
+	other := filepath.Join(parent, "other")
+	for _, d := range []string{dead, live, young, other} {
+		if err := os.Mkdir(d, 0700); err != nil {
+			t.Fatalf("Mkdir failed: %v", err)
+		}
+	}
+	writeOwner(t, dead, deadPID(t))
+	writeOwner(t, live, os.Getpid())
+
+	reaped, err := Reap(ctx, ReapOptions{Dir: parent, DryRun: true, Logf: t.Logf})
+	if err != nil {
+		t.Fatalf("Reap failed: %v", err)
+	}
+	if len(reaped) != 1 || reaped[0] != dead {
+		t.Fatalf("Unexpected dry run result: %v", reaped)
+	}
+	if _, err := os.Stat(dead); err != nil {
+		t.Fatalf("Dry run removed %s", dead)
+	}
+
+	reaped, err = Reap(ctx, ReapOptions{Dir: parent, Logf: t.Logf})
+	if err != nil {
+		t.Fatalf("Reap failed: %v", err)
+	}
+	if len(reaped) != 1 || reaped[0] != dead {
+		t.Fatalf("Unexpected result: %v", reaped)
+	}
+	if _, err := os.Stat(dead); !os.IsNotExist(err) {
+		t.Fatalf("Expected %s to be removed: %v", dead, err)
+	}
+	for _, d := range []string{live, young, other} {
+		if _, err := os.Stat(d); err != nil {
+			t.Fatalf("Expected %s to survive: %v", d, err)
+		}
+	}
+}
+
+func TestReapServer(t *testing.T) {
+	ctx := context.Background()
+	parent, err := ioutil.TempDir("", "test.")
+	if err != nil {
+		t.Fatalf("TempDir failed: %v", err)
+	}
+	defer os.RemoveAll(parent)
+	tmpDir := filepath.Join(parent, "briefpg.test.server")
+	if err := os.Mkdir(tmpDir, 0700); err != nil {
+		t.Fatalf("Mkdir failed: %v", err)
+	}
+
+	bpg, err := New(OptLogFunc(t.Logf), OptTmpDir(tmpDir))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	err = bpg.Start(ctx)
+	if err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	pms := postmasters(tmpDir)
+	if len(pms) != 1 || !processAlive(pms[0]) {
+		bpg.MustFini(ctx)
+		t.Fatalf("Expected a running server: %v", pms)
+	}
+
+	// Pretend that the owner has died.
+	writeOwner(t, tmpDir, deadPID(t))
+	reaped, err := Reap(ctx, ReapOptions{Dir: parent, Logf: t.Logf})
+	if err != nil || len(reaped) != 1 {
+		bpg.MustFini(ctx)
+		t.Fatalf("Reap failed: %v %v", reaped, err)
+	}
+	if processAlive(pms[0]) {
+		t.Fatalf("Expected server %d to be stopped", pms[0])
+	}
+	if _, err := os.Stat(tmpDir); !os.IsNotExist(err) {
+		t.Fatalf("Expected %s to be removed: %v", tmpDir, err)
+	}
+}
@@ -0,0 +1,72 @@
+/*
+ * COPYRIGHT 2020 Brightgate Inc.  All rights reserved.
+ *
+ * This copyright notice is Copyright Management Information under 17 USC 1202
+ * and is included to protect this work and deter copyright infringement.
+ * Removal or alteration of this Copyright Management Information without the
+ * express written permission of Brightgate Inc is prohibited, and any
+ * such unauthorized removal or alteration will be a violation of federal law.
+ */
+
+package briefpg
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+)
+
+// RestoreDB replays a dump, such as one written by DumpDB, into the named
+// database, which must already exist (see CreateDB).  Plain dumps (format
+// DumpPlain, or "" as in DumpOptions) are run with psql, stopping at the first
+// error, which is then a *SQLError.  Custom and tar archives are restored
+// with pg_restore, which must be installed alongside the other Postgres
+// utilities.  Use RestoreDBDir for directory archives.
+func (bp *BriefPG) RestoreDB(ctx context.Context, dbName string, r io.Reader, format DumpFormat) error {
+	if bp.state < stateServerStarted {
+		return fmt.Errorf("Server not started; cannot restore database")
+	}
+	switch format {
+	case DumpPlain, "":
+		return bp.execPsql(ctx, "RestoreDB", dbName, "-", r, ExecOptions{})
+	case DumpCustom, DumpTar:
+		return bp.pgRestore(ctx, dbName, r, "--format="+string(format))
+	case DumpDirectory:
+		return fmt.Errorf("RestoreDB cannot read a directory archive; " +
+			"use RestoreDBDir")
+	}
+	return fmt.Errorf("RestoreDB: unknown dump format %q", format)
+}
+
+// RestoreDBDir restores a directory archive, as written by pg_dump
+// --format=directory, into the named database, which must already exist.
+func (bp *BriefPG) RestoreDBDir(ctx context.Context, dbName, dir string) error {
+	if bp.state < stateServerStarted {
+		return fmt.Errorf("Server not started; cannot restore database")
+	}
+	return bp.pgRestore(ctx, dbName, nil, "--format=directory", dir)
+}
+
+// pgRestore runs pg_restore against dbName with the given arguments, reading
+// the archive from r if it isn't named in args.
+func (bp *BriefPG) pgRestore(ctx context.Context, dbName string, r io.Reader, args ...string) error {
+	pgRestore, ok := bp.pgCmds["pg_restore"]
+	if !ok {
+		return fmt.Errorf("RestoreDB failed: pg_restore is not installed")
+	}
+	args = append([]string{"--exit-on-error", "--dbname=" + bp.DBUri(dbName)}, args...)
+	cmd := exec.CommandContext(ctx, pgRestore, args...)
+	cmd.Stdin = r
+	bp.logf("briefpg: %s\n", strings.Join(cmd.Args, " "))
+	cmdOut, err := cmd.CombinedOutput()
+	out := strings.TrimSpace(string(cmdOut))
+	for _, line := range strings.Split(out, "\n") {
+		bp.logf("briefpg: %s\n", line)
+	}
+	if err != nil {
+		return wrapOutputErr(ctx, "RestoreDB failed", cmd, out, err)
+	}
+	return nil
+}
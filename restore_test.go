@@ -0,0 +1,109 @@
+/*
+ * COPYRIGHT 2020 Brightgate Inc.  All rights reserved.
+ *
+ * This copyright notice is Copyright Management Information under 17 USC 1202
+ * and is included to protect this work and deter copyright infringement.
+ * Removal or alteration of this Copyright Management Information without the
+ * express written permission of Brightgate Inc is prohibited, and any
+ * such unauthorized removal or alteration will be a violation of federal law.
+ */
+
+package briefpg
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRestoreDB(t *testing.T) {
+	ctx := context.Background()
+	bpg, err := New(OptLogFunc(t.Logf))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	err = bpg.RestoreDB(ctx, "test_db", strings.NewReader(""), DumpPlain)
+	if err == nil {
+		t.Fatalf("Expected RestoreDB to fail")
+	}
+	if err = bpg.Start(ctx); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer bpg.MustFini(ctx)
+	if _, err = bpg.CreateDB(ctx, "test_db", ""); err != nil {
+		t.Fatalf("CreateDB failed: %v", err)
+	}
+	err = bpg.ExecSQL(ctx, "test_db", "CREATE TABLE t (s text);\n"+
+		"INSERT INTO t VALUES ('a'), ('b'), (NULL);\n", ExecOptions{})
+	if err != nil {
+		t.Fatalf("ExecSQL failed: %v", err)
+	}
+
+	checkRestored := func(dbName string) {
+		t.Helper()
+		n, err := bpg.QueryInt(ctx, dbName, "SELECT count(*) FROM t")
+		if err != nil || n != 3 {
+			t.Fatalf("Unexpected count %d, %v", n, err)
+		}
+	}
+
+	var plain bytes.Buffer
+	if err = bpg.DumpDB(ctx, "test_db", &plain); err != nil {
+		t.Fatalf("DumpDB failed: %v", err)
+	}
+	if _, err = bpg.CreateDB(ctx, "plain_db", ""); err != nil {
+		t.Fatalf("CreateDB failed: %v", err)
+	}
+	// The zero format is plain, as in DumpOptions.
+	if err = bpg.RestoreDB(ctx, "plain_db", &plain, ""); err != nil {
+		t.Fatalf("RestoreDB failed: %v", err)
+	}
+	checkRestored("plain_db")
+
+	// Restoring on top of existing tables fails.
+	plain.Reset()
+	if err = bpg.DumpDB(ctx, "test_db", &plain); err != nil {
+		t.Fatalf("DumpDB failed: %v", err)
+	}
+	err = bpg.RestoreDB(ctx, "plain_db", &plain, DumpPlain)
+	var sqlErr *SQLError
+	if !errors.As(err, &sqlErr) {
+		t.Fatalf("Expected SQLError; got %v", err)
+	}
+
+	if _, ok := bpg.pgCmds["pg_restore"]; !ok {
+		t.Skipf("pg_restore is not installed")
+	}
+	for _, format := range []DumpFormat{DumpCustom, DumpTar, DumpDirectory} {
+		dbName := string(format) + "_db"
+		if _, err = bpg.CreateDB(ctx, dbName, ""); err != nil {
+			t.Fatalf("CreateDB failed: %v", err)
+		}
+		if format == DumpDirectory {
+			dir := filepath.Join(t.TempDir(), "dump")
+			if err = bpg.DumpDBDir(ctx, "test_db", dir, DumpOptions{}); err != nil {
+				t.Fatalf("DumpDBDir failed: %v", err)
+			}
+			err = bpg.RestoreDBDir(ctx, dbName, dir)
+		} else {
+			var archive bytes.Buffer
+			err = bpg.DumpDBWithOptions(ctx, "test_db", &archive,
+				DumpOptions{Format: format})
+			if err != nil {
+				t.Fatalf("DumpDBWithOptions failed: %v", err)
+			}
+			err = bpg.RestoreDB(ctx, dbName, &archive, format)
+		}
+		if err != nil {
+			t.Fatalf("Restore of %s failed: %v", format, err)
+		}
+		checkRestored(dbName)
+	}
+	err = bpg.RestoreDB(ctx, "test_db", strings.NewReader(""), DumpDirectory)
+	if err == nil {
+		t.Fatalf("Expected RestoreDB of directory format to fail")
+	}
+}
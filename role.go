@@ -0,0 +1,114 @@
+/*
+ * COPYRIGHT 2020 Brightgate Inc.  All rights reserved.
+ *
+ * This copyright notice is Copyright Management Information under 17 USC 1202
+ * and is included to protect this work and deter copyright infringement.
+ * Removal or alteration of this Copyright Management Information without the
+ * express written permission of Brightgate Inc is prohibited, and any
+ * such unauthorized removal or alteration will be a violation of federal law.
+ */
+
+package briefpg
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// RoleOptions describes the attributes of a role made by CreateRole.  The
+// zero value describes a role which can't log in, and has no privileges.
+type RoleOptions struct {
+	Password  string   // Role's password; "" for none
+	Login     bool     // Role may log in
+	Superuser bool     // Role bypasses all permission checks
+	CreateDB  bool     // Role may create databases
+	InRoles   []string // Existing roles of which the new role is a member
+}
+
+// CreateRole creates a database role (a user or group).  Roles are shared by
+// all databases in the cluster.  This is useful for testing code which runs
+// with restricted privileges; use DBUriAs to connect as the role.
+func (bp *BriefPG) CreateRole(ctx context.Context, name string, ro RoleOptions) error {
+	if bp.state < stateServerStarted {
+		return fmt.Errorf("Server not started; cannot create role")
+	}
+	attrs := []string{"NOLOGIN", "NOSUPERUSER", "NOCREATEDB"}
+	if ro.Login {
+		attrs[0] = "LOGIN"
+	}
+	if ro.Superuser {
+		attrs[1] = "SUPERUSER"
+	}
+	if ro.CreateDB {
+		attrs[2] = "CREATEDB"
+	}
+	if ro.Password != "" {
+		attrs = append(attrs, "PASSWORD "+quoteLiteral(ro.Password))
+	}
+	if len(ro.InRoles) > 0 {
+		attrs = append(attrs, "IN ROLE "+quoteIdents(ro.InRoles))
+	}
+
+	// Passwords must be hashed to suit the authentication method.
+	scmd := fmt.Sprintf("SET password_encryption = %s; CREATE ROLE %s WITH %s",
+		quoteLiteral(bp.passwordEncryption()), quoteIdent(name),
+		strings.Join(attrs, " "))
+	if _, err := bp.psql(ctx, "CreateRole", "postgres", scmd); err != nil {
+		return err
+	}
+	if ro.Password != "" {
+		if bp.rolePasswords == nil {
+			bp.rolePasswords = make(confMap)
+		}
+		bp.rolePasswords[name] = ro.Password
+	}
+	return nil
+}
+
+// GrantRole makes member a member of role, so that it has role's privileges.
+func (bp *BriefPG) GrantRole(ctx context.Context, role, member string) error {
+	if bp.state < stateServerStarted {
+		return fmt.Errorf("Server not started; cannot grant role")
+	}
+	scmd := fmt.Sprintf("GRANT %s TO %s", quoteIdent(role), quoteIdent(member))
+	_, err := bp.psql(ctx, "GrantRole", "postgres", scmd)
+	return err
+}
+
+// Grant runs "GRANT privileges ON object TO role" in the named database.  The
+// privileges and object are SQL, and are used verbatim, so that any form of
+// GRANT may be expressed; for example:
+//
+//	bpg.Grant(ctx, "app", "SELECT, INSERT", "ALL TABLES IN SCHEMA public", "app_user")
+//	bpg.Grant(ctx, "app", "CONNECT", "DATABASE app", "app_user")
+//
+// The role name is quoted.
+func (bp *BriefPG) Grant(ctx context.Context, dbName, privileges, object, role string) error {
+	if bp.state < stateServerStarted {
+		return fmt.Errorf("Server not started; cannot grant privileges")
+	}
+	scmd := fmt.Sprintf("GRANT %s ON %s TO %s", privileges, object, quoteIdent(role))
+	_, err := bp.psql(ctx, "Grant", dbName, scmd)
+	return err
+}
+
+// DBUriAs returns the connection URI for a named database, like DBUri, but
+// connecting as the given role.  If the role was made by CreateRole with a
+// password, the URI includes it; for the superuser, the URI includes the
+// password set with OptAuth, as DBUri does.
+func (bp *BriefPG) DBUriAs(dbName, role string) string {
+	if role == superUser {
+		return bp.DBUri(dbName)
+	}
+	return bp.uri(dbName, role, bp.rolePasswords[role])
+}
+
+// quoteIdents quotes each of names, and joins them with commas.
+func quoteIdents(names []string) string {
+	quoted := make([]string, len(names))
+	for i, name := range names {
+		quoted[i] = quoteIdent(name)
+	}
+	return strings.Join(quoted, ", ")
+}
@@ -0,0 +1,93 @@
+/*
+ * COPYRIGHT 2020 Brightgate Inc.  All rights reserved.
+ *
+ * This copyright notice is Copyright Management Information under 17 USC 1202
+ * and is included to protect this work and deter copyright infringement.
+ * Removal or alteration of this Copyright Management Information without the
+ * express written permission of Brightgate Inc is prohibited, and any
+ * such unauthorized removal or alteration will be a violation of federal law.
+ */
+
+package briefpg
+
+import (
+	"context"
+	"os/exec"
+	"testing"
+)
+
+// psqlAs runs a query as the given role, reporting whether it succeeded.
+func psqlAs(t *testing.T, bpg *BriefPG, dbName, role, query string) bool {
+	cmd := exec.Command(bpg.pgCmds["psql"], "-X", "-w", "-v", "ON_ERROR_STOP=1",
+		"-c", query, bpg.DBUriAs(dbName, role))
+	out, err := cmd.CombinedOutput()
+	t.Logf("as %s: %s: %s", role, query, out)
+	return err == nil
+}
+
+func TestRoles(t *testing.T) {
+	ctx := context.Background()
+	for _, method := range []AuthMethod{AuthTrust, AuthSCRAM} {
+		bpg, err := New(OptLogFunc(t.Logf), OptAuth(method, ""))
+		if err != nil {
+			t.Fatalf("New failed: %v", err)
+		}
+		if err = bpg.CreateRole(ctx, "app", RoleOptions{}); err == nil {
+			t.Fatalf("Expected CreateRole to fail")
+		}
+		if err = bpg.Start(ctx); err != nil {
+			t.Fatalf("Start failed: %v", err)
+		}
+		if _, err = bpg.CreateDB(ctx, "test_db", ""); err != nil {
+			t.Fatalf("CreateDB failed: %v", err)
+		}
+
+		err = bpg.CreateRole(ctx, "readers", RoleOptions{})
+		if err != nil {
+			t.Fatalf("CreateRole failed: %v", err)
+		}
+		err = bpg.CreateRole(ctx, "app's user", RoleOptions{
+			Password: "secret",
+			Login:    true,
+			InRoles:  []string{"readers"},
+		})
+		if err != nil {
+			t.Fatalf("CreateRole failed: %v", err)
+		}
+		err = bpg.CreateRole(ctx, "writer", RoleOptions{Password: "secret2", Login: true})
+		if err != nil {
+			t.Fatalf("CreateRole failed: %v", err)
+		}
+		if err = bpg.CreateRole(ctx, "writer", RoleOptions{}); err == nil {
+			t.Fatalf("Expected duplicate CreateRole to fail")
+		}
+
+		if !psqlAs(t, bpg, "test_db", superUser, "CREATE TABLE t (i int)") {
+			t.Fatalf("Expected superuser to create table")
+		}
+		if psqlAs(t, bpg, "test_db", "app's user", "SELECT * FROM t") {
+			t.Fatalf("Expected restricted role to be denied")
+		}
+		if psqlAs(t, bpg, "test_db", "readers", "SELECT 1") {
+			t.Fatalf("Expected NOLOGIN role to be denied")
+		}
+
+		err = bpg.Grant(ctx, "test_db", "SELECT", "TABLE t", "readers")
+		if err != nil {
+			t.Fatalf("Grant failed: %v", err)
+		}
+		if !psqlAs(t, bpg, "test_db", "app's user", "SELECT * FROM t") {
+			t.Fatalf("Expected role to inherit SELECT")
+		}
+		if psqlAs(t, bpg, "test_db", "writer", "SELECT * FROM t") {
+			t.Fatalf("Expected writer to be denied")
+		}
+		if err = bpg.GrantRole(ctx, "readers", "writer"); err != nil {
+			t.Fatalf("GrantRole failed: %v", err)
+		}
+		if !psqlAs(t, bpg, "test_db", "writer", "SELECT * FROM t") {
+			t.Fatalf("Expected writer to inherit SELECT")
+		}
+		bpg.MustFini(ctx)
+	}
+}
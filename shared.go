@@ -0,0 +1,315 @@
+/*
+ * COPYRIGHT 2020 Brightgate Inc.  All rights reserved.
+ *
+ * This copyright notice is Copyright Management Information under 17 USC 1202
+ * and is included to protect this work and deter copyright infringement.
+ * Removal or alteration of this Copyright Management Information without the
+ * express written permission of Brightgate Inc is prohibited, and any
+ * such unauthorized removal or alteration will be a violation of federal law.
+ */
+
+package briefpg
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// sharedLockFile serializes the participants of a shared directory
+	// while they start, attach to and detach from its servers.
+	sharedLockFile = "lock"
+
+	// participantsDir holds one file for each BriefPG attached to the
+	// servers in a shared directory, named "<pid>.<sequence>".
+	participantsDir = "participants"
+
+	// sharedInfoFile is written into the data directory of a shared
+	// server, and describes how to connect to it.
+	sharedInfoFile = "briefpg.json"
+
+	// lockPollInterval is how often lockShared retries a held lock.
+	lockPollInterval = 50 * time.Millisecond
+)
+
+// errLocked is returned by tryLockFile when another process holds the lock.
+var errLocked = errors.New("file is locked")
+
+// participantSeq distinguishes the participant files of BriefPG instances
+// in the same process.
+var participantSeq int32
+
+// shareState holds the state of a BriefPG which uses a shared server.
+type shareState struct {
+	dir         string // Shared directory
+	participant string // Our participant file, once attached
+}
+
+// sharedInfo is the content of sharedInfoFile.
+type sharedInfo struct {
+	Port     int    `json:"port"`
+	Password string `json:"password"`
+}
+
+// defaultSharedDir returns the shared directory used if OptShared is given
+// "".  It is named so that Reap can find it.
+func defaultSharedDir() string {
+	name := "briefpg.shared"
+	if u, err := user.Current(); err == nil && u.Username != "" {
+		name += "." + u.Username
+	}
+	return filepath.Join(os.TempDir(), name)
+}
+
+func (bp *BriefPG) setShared(dir string) error {
+	if bp.state >= stateInitialized {
+		return fmt.Errorf("shared server cannot be used after initialization")
+	}
+	if dir == "" {
+		dir = defaultSharedDir()
+	}
+	bp.shared = &shareState{dir: dir}
+	return nil
+}
+
+// lockShared takes the lock on the shared directory dir, creating it if
+// needed, and waiting for other participants to release the lock.  Close the
+// returned file to release the lock.
+func lockShared(ctx context.Context, dir string) (*os.File, error) {
+	if err := os.MkdirAll(filepath.Join(dir, participantsDir), 0700); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(filepath.Join(dir, sharedLockFile), os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		err = tryLockFile(f)
+		if err == nil {
+			return f, nil
+		}
+		if !errors.Is(err, errLocked) {
+			f.Close()
+			return nil, err
+		}
+		select {
+		case <-ctx.Done():
+			f.Close()
+			return nil, ctx.Err()
+		case <-time.After(lockPollInterval):
+		}
+	}
+}
+
+// participants returns the number of live participants in the shared
+// directory dir.  If clean is set, the files of participants whose process
+// has exited are removed.
+func participants(dir string, clean bool, logf LogFunction) int {
+	pdir := filepath.Join(dir, participantsDir)
+	files, err := ioutil.ReadDir(pdir)
+	if err != nil {
+		return 0
+	}
+	live := 0
+	for _, fi := range files {
+		pid, err := strconv.Atoi(strings.SplitN(fi.Name(), ".", 2)[0])
+		if err == nil && processAlive(pid) {
+			live++
+			continue
+		}
+		if clean {
+			logf("briefpg: %s: removing stale participant %s\n", dir, fi.Name())
+			os.Remove(filepath.Join(pdir, fi.Name()))
+		}
+	}
+	return live
+}
+
+// sharedServerRunning reports whether a server is running in dataDir.
+func sharedServerRunning(dataDir string) bool {
+	pid, err := readPIDFile(filepath.Join(dataDir, "postmaster.pid"))
+	return err == nil && processAlive(pid) && isPostmaster(pid)
+}
+
+// startShared implements Start for a BriefPG using a shared server.  If a
+// server for this version of Postgres is already running in the shared
+// directory, we attach to it; otherwise we make and start one.  In either
+// case, we register as a participant, so that the server is left running
+// until every participant has called Fini.
+func (bp *BriefPG) startShared(ctx context.Context) error {
+	if bp.useTLS {
+		return fmt.Errorf("OptTLS cannot be used with a shared server")
+	}
+	lock, err := lockShared(ctx, bp.shared.dir)
+	if err != nil {
+		return fmt.Errorf("Failed to lock shared directory %s: %w",
+			bp.shared.dir, err)
+	}
+	defer lock.Close()
+	participants(bp.shared.dir, true, bp.logf)
+
+	// Each version has its own directory, so that their sockets don't
+	// collide.
+	bp.tmpDir = filepath.Join(bp.shared.dir, bp.PgVer())
+	infoFile := filepath.Join(bp.DbDir(), sharedInfoFile)
+	started := false
+	if sharedServerRunning(bp.DbDir()) {
+		b, err := ioutil.ReadFile(infoFile)
+		if err != nil {
+			return fmt.Errorf("Failed to read shared server info: %w", err)
+		}
+		var info sharedInfo
+		if err = json.Unmarshal(b, &info); err != nil {
+			return fmt.Errorf("Failed to parse %s: %w", infoFile, err)
+		}
+		bp.logf("briefpg: attaching to shared server in %s\n", bp.DbDir())
+		bp.port = info.Port
+		bp.password = info.Password
+		bp.listenTCP = info.Port != 0
+	} else {
+		// Anything here was left by a participant which crashed.
+		if err := os.RemoveAll(bp.tmpDir); err != nil {
+			return fmt.Errorf("Failed to clean shared directory: %w", err)
+		}
+		if err := os.MkdirAll(bp.tmpDir, 0700); err != nil {
+			return fmt.Errorf("Failed to make shared directory: %w", err)
+		}
+		if err := bp.initDB(ctx); err != nil {
+			return err
+		}
+		if err := bp.startServer(ctx); err != nil {
+			return err
+		}
+		info := sharedInfo{Password: bp.password}
+		if bp.listenTCP {
+			info.Port = bp.port
+		}
+		b, _ := json.Marshal(info)
+		if err := ioutil.WriteFile(infoFile, b, 0600); err != nil {
+			return bp.abortShared(ctx, fmt.Errorf(
+				"Failed to write shared server info: %w", err))
+		}
+		started = true
+	}
+
+	seq := atomic.AddInt32(&participantSeq, 1)
+	participant := filepath.Join(bp.shared.dir, participantsDir,
+		fmt.Sprintf("%d.%d", os.Getpid(), seq))
+	if err := ioutil.WriteFile(participant, nil, 0600); err != nil {
+		err = fmt.Errorf("Failed to register as participant: %w", err)
+		if started {
+			return bp.abortShared(ctx, err)
+		}
+		return err
+	}
+	bp.state = stateServerStarted
+	bp.shared.participant = participant
+	return nil
+}
+
+// abortShared stops and removes the server which startShared started, when
+// startShared fails after starting it; otherwise the server would be left
+// running with no participant to stop it.  The caller must hold the lock.
+// It returns err.
+func (bp *BriefPG) abortShared(ctx context.Context, err error) error {
+	if cerr := cleanShared(ctx, bp.tmpDir); cerr != nil {
+		bp.logf("briefpg: %v\n", cerr)
+	}
+	bp.state = stateUninitialized
+	return err
+}
+
+// finiShared implements Fini for a BriefPG using a shared server.  The last
+// participant to leave stops the servers and cleans up the shared directory.
+func (bp *BriefPG) finiShared(ctx context.Context) error {
+	if bp.shared.participant == "" {
+		bp.state = stateDefunct
+		return nil
+	}
+	lock, err := lockShared(ctx, bp.shared.dir)
+	if err != nil {
+		return fmt.Errorf("Failed to lock shared directory %s: %w",
+			bp.shared.dir, err)
+	}
+	defer lock.Close()
+	os.Remove(bp.shared.participant)
+	bp.shared.participant = ""
+	bp.state = stateDefunct
+
+	if live := participants(bp.shared.dir, true, bp.logf); live > 0 {
+		bp.logf("briefpg: leaving shared server running for %d "+
+			"other participants\n", live)
+		return nil
+	}
+	bp.logf("briefpg: last participant; stopping shared servers in %s\n",
+		bp.shared.dir)
+	return cleanShared(ctx, bp.shared.dir)
+}
+
+// cleanShared stops the servers in the shared directory dir (or in one
+// version's directory within it), and removes their directories.  The caller must hold the lock.  The lock file and the
+// participants directory are kept, as others may be waiting to lock the
+// former.
+func cleanShared(ctx context.Context, dir string) error {
+	for _, pm := range postmasters(dir) {
+		if !processAlive(pm) || !isPostmaster(pm) {
+			continue
+		}
+		if err := stopPostmaster(ctx, pm); err != nil {
+			return fmt.Errorf("Failed to stop shared server %d: %w", pm, err)
+		}
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("Failed to clean shared directory: %w", err)
+	}
+	for _, fi := range files {
+		if fi.Name() != sharedLockFile && fi.Name() != participantsDir {
+			os.RemoveAll(filepath.Join(dir, fi.Name()))
+		}
+	}
+	return nil
+}
+
+// reapShared implements Reap for the shared directory dir.  If no process
+// holds the lock, and no participants are running, the servers are stopped
+// and cleaned up as by the last participant to leave.  The lock is held
+// throughout, so that a participant can't start a server meanwhile.  It
+// reports whether dir was (or, if dryRun is set, would be) reaped.
+func reapShared(ctx context.Context, dir string, dryRun bool, logf LogFunction) (bool, error) {
+	lock, err := os.OpenFile(filepath.Join(dir, sharedLockFile), os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return false, fmt.Errorf("%s: failed to open lock: %w", dir, err)
+	}
+	defer lock.Close()
+	if err = tryLockFile(lock); errors.Is(err, errLocked) {
+		logf("briefpg: %s: shared directory is locked\n", dir)
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("%s: failed to lock: %w", dir, err)
+	}
+
+	if live := participants(dir, !dryRun, logf); live > 0 {
+		logf("briefpg: %s: %d participants are running\n", dir, live)
+		return false, nil
+	}
+	if dryRun {
+		logf("briefpg: would reap %s\n", dir)
+		return true, nil
+	}
+	logf("briefpg: %s: cleaning shared directory\n", dir)
+	if err := cleanShared(ctx, dir); err != nil {
+		return false, fmt.Errorf("%s: %w", dir, err)
+	}
+	return true, nil
+}
@@ -0,0 +1,195 @@
+/*
+ * COPYRIGHT 2020 Brightgate Inc.  All rights reserved.
+ *
+ * This copyright notice is Copyright Management Information under 17 USC 1202
+ * and is included to protect this work and deter copyright infringement.
+ * Removal or alteration of this Copyright Management Information without the
+ * express written permission of Brightgate Inc is prohibited, and any
+ * such unauthorized removal or alteration will be a violation of federal law.
+ */
+
+package briefpg
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// addParticipant registers a participant with the given PID in dir.
+func addParticipant(t *testing.T, dir string, pid int) string {
+	pdir := filepath.Join(dir, participantsDir)
+	if err := os.MkdirAll(pdir, 0700); err != nil {
+		t.Fatalf("MkdirAll failed: %v", err)
+	}
+	name := filepath.Join(pdir, fmt.Sprintf("%d.1", pid))
+	if err := ioutil.WriteFile(name, nil, 0600); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	return name
+}
+
+func TestParticipants(t *testing.T) {
+	dir := t.TempDir()
+	if n := participants(dir, true, t.Logf); n != 0 {
+		t.Fatalf("Expected no participants; got %d", n)
+	}
+	addParticipant(t, dir, os.Getpid())
+	dead := addParticipant(t, dir, deadPID(t))
+
+	if n := participants(dir, false, t.Logf); n != 1 {
+		t.Fatalf("Expected 1 participant; got %d", n)
+	}
+	if _, err := os.Stat(dead); err != nil {
+		t.Fatalf("Expected stale participant to be kept: %v", err)
+	}
+	if n := participants(dir, true, t.Logf); n != 1 {
+		t.Fatalf("Expected 1 participant; got %d", n)
+	}
+	if _, err := os.Stat(dead); !os.IsNotExist(err) {
+		t.Fatalf("Expected stale participant to be removed: %v", err)
+	}
+}
+
+func TestReapShared(t *testing.T) {
+	ctx := context.Background()
+	parent := t.TempDir()
+	dir := filepath.Join(parent, "briefpg.shared.test")
+	dead := addParticipant(t, dir, deadPID(t))
+	verDir := filepath.Join(dir, "16.2")
+	if err := os.Mkdir(verDir, 0700); err != nil {
+		t.Fatalf("Mkdir failed: %v", err)
+	}
+	reap := func(dryRun bool) []string {
+		t.Helper()
+		reaped, err := Reap(ctx, ReapOptions{Dir: parent, DryRun: dryRun, Logf: t.Logf})
+		if err != nil {
+			t.Fatalf("Reap failed: %v", err)
+		}
+		return reaped
+	}
+
+	if reaped := reap(true); len(reaped) != 1 || reaped[0] != dir {
+		t.Fatalf("Expected dry run to report %s; got %q", dir, reaped)
+	}
+	if _, err := os.Stat(dead); err != nil {
+		t.Fatalf("Dry run removed participant: %v", err)
+	}
+
+	// A participant which is starting up holds the lock.
+	lock, err := lockShared(ctx, dir)
+	if err != nil {
+		t.Fatalf("lockShared failed: %v", err)
+	}
+	if reaped := reap(false); len(reaped) != 0 {
+		t.Fatalf("Expected locked directory not to be reaped")
+	}
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err = lockShared(cctx, dir); err != context.Canceled {
+		t.Fatalf("Expected canceled lockShared; got %v", err)
+	}
+	live := addParticipant(t, dir, os.Getpid())
+	lock.Close()
+	if reaped := reap(false); len(reaped) != 0 {
+		t.Fatalf("Expected directory with live participant not to be reaped")
+	}
+	os.Remove(live)
+
+	if reaped := reap(false); len(reaped) != 1 || reaped[0] != dir {
+		t.Fatalf("Expected %s to be reaped; got %q", dir, reaped)
+	}
+	if _, err := os.Stat(verDir); !os.IsNotExist(err) {
+		t.Fatalf("Expected %s to be removed: %v", verDir, err)
+	}
+	if _, err := os.Stat(dead); !os.IsNotExist(err) {
+		t.Fatalf("Expected stale participant to be removed: %v", err)
+	}
+	for _, name := range []string{sharedLockFile, participantsDir} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Fatalf("Expected %s to be kept: %v", name, err)
+		}
+	}
+}
+
+func TestShared(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	// A participant which crashed
+	addParticipant(t, dir, deadPID(t))
+
+	var bpgs [2]*BriefPG
+	for i := range bpgs {
+		bpg, err := New(OptLogFunc(t.Logf), OptShared(dir), OptListenTCP(),
+			OptAuth(AuthSCRAM, ""), OptWatchdog())
+		if err != nil {
+			t.Fatalf("New failed: %v", err)
+		}
+		defer bpg.Fini(ctx)
+		if err = bpg.Start(ctx); err != nil {
+			t.Fatalf("Start failed: %v", err)
+		}
+		if bpg.watchdog != nil {
+			t.Fatalf("Expected no watchdog for shared server")
+		}
+		bpgs[i] = bpg
+	}
+	a, b := bpgs[0], bpgs[1]
+	if a.DbDir() != b.DbDir() || a.Port() != b.Port() || a.Password() != b.Password() {
+		t.Fatalf("Expected second instance to attach to the first")
+	}
+	if n := participants(dir, false, t.Logf); n != 2 {
+		t.Fatalf("Expected 2 participants; got %d", n)
+	}
+	if err := b.Stop(ctx, StopFast); err == nil {
+		t.Fatalf("Expected Stop of shared server to fail")
+	}
+
+	if _, err := a.CreateDB(ctx, "test_db", ""); err != nil {
+		t.Fatalf("CreateDB failed: %v", err)
+	}
+	if err := a.StartPool(ctx, "test_db", 2); err != nil {
+		t.Fatalf("StartPool failed: %v", err)
+	}
+	pooled, err := a.AcquireDB(ctx)
+	if err != nil {
+		t.Fatalf("AcquireDB failed: %v", err)
+	}
+	if err = a.ReleaseDB(pooled); err != nil {
+		t.Fatalf("ReleaseDB failed: %v", err)
+	}
+	if err := a.Fini(ctx); err != nil {
+		t.Fatalf("Fini failed: %v", err)
+	}
+	exists, err := b.DatabaseExists(ctx, "test_db")
+	if err != nil || !exists {
+		t.Fatalf("Expected server to outlive first participant: %v", err)
+	}
+	names, err := b.ListDatabases(ctx)
+	if err != nil {
+		t.Fatalf("ListDatabases failed: %v", err)
+	}
+	for _, name := range names {
+		if strings.HasPrefix(name, poolPrefix) {
+			t.Fatalf("Expected Fini to drop pooled database %s", name)
+		}
+	}
+
+	dataDir := b.DbDir()
+	if err := b.Fini(ctx); err != nil {
+		t.Fatalf("Fini failed: %v", err)
+	}
+	if sharedServerRunning(dataDir) {
+		t.Fatalf("Expected last participant to stop the server")
+	}
+	if _, err := os.Stat(dataDir); !os.IsNotExist(err) {
+		t.Fatalf("Expected shared directory to be cleaned: %v", err)
+	}
+	if n := participants(dir, false, t.Logf); n != 0 {
+		t.Fatalf("Expected no participants; got %d", n)
+	}
+}
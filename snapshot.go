@@ -0,0 +1,88 @@
+/*
+ * COPYRIGHT 2020 Brightgate Inc.  All rights reserved.
+ *
+ * This copyright notice is Copyright Management Information under 17 USC 1202
+ * and is included to protect this work and deter copyright infringement.
+ * Removal or alteration of this Copyright Management Information without the
+ * express written permission of Brightgate Inc is prohibited, and any
+ * such unauthorized removal or alteration will be a violation of federal law.
+ */
+
+package briefpg
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// snapshotPrefix begins the names of the databases which hold snapshots.
+const snapshotPrefix = "briefpg_snapshot_"
+
+// snapshotDBName returns the name of the database holding the named snapshot
+// of dbName.  The names are hashed to keep the result within Postgres's
+// identifier length limit.
+func snapshotDBName(dbName, name string) string {
+	sum := sha256.Sum256([]byte(dbName + "\x00" + name))
+	return snapshotPrefix + hex.EncodeToString(sum[:16])
+}
+
+// Snapshot saves the current state of the named database as a snapshot
+// called name, replacing any existing snapshot of that name.  Use Reset to
+// return the database to the saved state.  Snapshots are held in hidden
+// template databases, which ListDatabases omits.  Postgres can't copy a
+// database which has active connections, so any connections to dbName are
+// terminated.
+func (bp *BriefPG) Snapshot(ctx context.Context, dbName, name string) error {
+	if bp.state < stateServerStarted {
+		return fmt.Errorf("Server not started; cannot snapshot database")
+	}
+	snapName := snapshotDBName(dbName, name)
+	if err := bp.DropDB(ctx, snapName, true); err != nil {
+		return err
+	}
+	if err := bp.terminateConnections(ctx, dbName); err != nil {
+		return err
+	}
+	_, err := bp.CloneDB(ctx, dbName, snapName)
+	return err
+}
+
+// Reset returns the named database to the state saved by Snapshot, by
+// dropping it and recreating it from the snapshot.  Connections to the
+// database, such as those held by the code under test, are terminated.  The
+// time taken is returned, so that tests can report or bound it.
+func (bp *BriefPG) Reset(ctx context.Context, dbName, name string) (time.Duration, error) {
+	if bp.state < stateServerStarted {
+		return 0, fmt.Errorf("Server not started; cannot reset database")
+	}
+	start := time.Now()
+	snapName := snapshotDBName(dbName, name)
+	exists, err := bp.DatabaseExists(ctx, snapName)
+	if err != nil {
+		return 0, err
+	}
+	if !exists {
+		return 0, fmt.Errorf("Reset failed: no snapshot %q of %q", name, dbName)
+	}
+	if err = bp.DropDB(ctx, dbName, true); err != nil {
+		return 0, err
+	}
+	if _, err = bp.CloneDB(ctx, snapName, dbName); err != nil {
+		return 0, err
+	}
+	elapsed := time.Since(start)
+	bp.logf("briefpg: reset %s to snapshot %s in %v\n", dbName, name, elapsed)
+	return elapsed, nil
+}
+
+// DropSnapshot discards the named snapshot of dbName; discarding a snapshot
+// which doesn't exist is not an error.
+func (bp *BriefPG) DropSnapshot(ctx context.Context, dbName, name string) error {
+	if bp.state < stateServerStarted {
+		return fmt.Errorf("Server not started; cannot drop snapshot")
+	}
+	return bp.DropDB(ctx, snapshotDBName(dbName, name), true)
+}
@@ -0,0 +1,132 @@
+/*
+ * COPYRIGHT 2020 Brightgate Inc.  All rights reserved.
+ *
+ * This copyright notice is Copyright Management Information under 17 USC 1202
+ * and is included to protect this work and deter copyright infringement.
+ * Removal or alteration of this Copyright Management Information without the
+ * express written permission of Brightgate Inc is prohibited, and any
+ * such unauthorized removal or alteration will be a violation of federal law.
+ */
+
+package briefpg
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"testing"
+)
+
+func TestSnapshot(t *testing.T) {
+	ctx := context.Background()
+	bpg, err := New(OptLogFunc(t.Logf))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if err = bpg.Snapshot(ctx, "test_db", "base"); err == nil {
+		t.Fatalf("Expected Snapshot to fail")
+	}
+	if err = bpg.Start(ctx); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer bpg.MustFini(ctx)
+	if _, err = bpg.CreateDB(ctx, "test_db", ""); err != nil {
+		t.Fatalf("CreateDB failed: %v", err)
+	}
+	if _, err = bpg.Reset(ctx, "test_db", "base"); err == nil {
+		t.Fatalf("Expected Reset without snapshot to fail")
+	}
+	exists, err := bpg.DatabaseExists(ctx, "test_db")
+	if err != nil || !exists {
+		t.Fatalf("Expected failed Reset to leave database: %v", err)
+	}
+
+	count := func() int64 {
+		t.Helper()
+		n, err := bpg.QueryInt(ctx, "test_db", "SELECT count(*) FROM t")
+		if err != nil {
+			t.Fatalf("QueryInt failed: %v", err)
+		}
+		return n
+	}
+
+	err = bpg.ExecSQL(ctx, "test_db", "CREATE TABLE t (i int); INSERT INTO t VALUES (1);",
+		ExecOptions{})
+	if err != nil {
+		t.Fatalf("ExecSQL failed: %v", err)
+	}
+	if err = bpg.Snapshot(ctx, "test_db", "base"); err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+	names, err := bpg.ListDatabases(ctx)
+	if err != nil {
+		t.Fatalf("ListDatabases failed: %v", err)
+	}
+	if fmt.Sprint(names) != "[postgres test_db]" {
+		t.Fatalf("Expected snapshot to be hidden; got %q", names)
+	}
+
+	// Hold a connection open, as the code under test might.
+	psql := exec.Command(bpg.pgCmds["psql"], "-X", bpg.DBUri("test_db"))
+	stdin, err := psql.StdinPipe()
+	if err != nil {
+		t.Fatalf("StdinPipe failed: %v", err)
+	}
+	if err = psql.Start(); err != nil {
+		t.Fatalf("psql failed: %v", err)
+	}
+	defer psql.Wait()
+	defer stdin.Close()
+
+	for i := 0; i < 2; i++ {
+		err = bpg.ExecSQL(ctx, "test_db", "INSERT INTO t VALUES (2), (3);", ExecOptions{})
+		if err != nil {
+			t.Fatalf("ExecSQL failed: %v", err)
+		}
+		if n := count(); n != 3 {
+			t.Fatalf("Expected 3 rows; got %d", n)
+		}
+		elapsed, err := bpg.Reset(ctx, "test_db", "base")
+		if err != nil {
+			t.Fatalf("Reset failed: %v", err)
+		}
+		if elapsed <= 0 {
+			t.Fatalf("Unexpected reset time %v", elapsed)
+		}
+		if n := count(); n != 1 {
+			t.Fatalf("Expected 1 row after Reset; got %d", n)
+		}
+	}
+
+	// Snapshots of the same name replace each other.
+	err = bpg.ExecSQL(ctx, "test_db", "INSERT INTO t VALUES (2);", ExecOptions{})
+	if err != nil {
+		t.Fatalf("ExecSQL failed: %v", err)
+	}
+	if err = bpg.Snapshot(ctx, "test_db", "base"); err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+	if _, err = bpg.Reset(ctx, "test_db", "base"); err != nil {
+		t.Fatalf("Reset failed: %v", err)
+	}
+	if n := count(); n != 2 {
+		t.Fatalf("Expected 2 rows after Reset; got %d", n)
+	}
+
+	if err = bpg.DropSnapshot(ctx, "test_db", "base"); err != nil {
+		t.Fatalf("DropSnapshot failed: %v", err)
+	}
+	if _, err = bpg.Reset(ctx, "test_db", "base"); err == nil {
+		t.Fatalf("Expected Reset after DropSnapshot to fail")
+	}
+}
+
+func TestSnapshotDBName(t *testing.T) {
+	a := snapshotDBName("db", "name")
+	if len(a) > maxIdentLen || a != snapshotDBName("db", "name") {
+		t.Fatalf("Unexpected snapshot name %q", a)
+	}
+	if a == snapshotDBName("dbn", "ame") || a == snapshotDBName("db", "other") {
+		t.Fatalf("Expected distinct snapshot names")
+	}
+}
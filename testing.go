@@ -0,0 +1,270 @@
+/*
+ * COPYRIGHT 2020 Brightgate Inc.  All rights reserved.
+ *
+ * This copyright notice is Copyright Management Information under 17 USC 1202
+ * and is included to protect this work and deter copyright infringement.
+ * Removal or alteration of this Copyright Management Information without the
+ * express written permission of Brightgate Inc is prohibited, and any
+ * such unauthorized removal or alteration will be a violation of federal law.
+ */
+
+package briefpg
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+// maxIdentLen is the longest identifier Postgres accepts (NAMEDATALEN - 1).
+const maxIdentLen = 63
+
+// NewT returns a started BriefPG whose lifetime is tied to the test t.  Log
+// output is sent to t.Logf, and Fini() is registered with t.Cleanup.  Options
+// are applied as with New().  If PostgreSQL can't be found, the test fails,
+// unless OptSkipIfNotInstalled is present, in which case it is skipped.  Any
+// other failure to create or start the instance fails the test.
+func NewT(t testing.TB, options ...Option) *BriefPG {
+	t.Helper()
+	options = append([]Option{OptLogFunc(t.Logf)}, options...)
+	bpg, err := New(options...)
+	if err != nil {
+		if wantSkip(options) && errors.Is(err, ErrPostgresNotFound) {
+			t.Skipf("briefpg: skipping test: %v", err)
+		}
+		t.Fatalf("briefpg: New failed: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := bpg.Fini(context.Background()); err != nil {
+			t.Errorf("briefpg: Fini failed: %v", err)
+		}
+	})
+
+	if err := bpg.Start(context.Background()); err != nil {
+		t.Fatalf("briefpg: Start failed: %v", err)
+	}
+	return bpg
+}
+
+// The instance shared by the tests in a package, if started by Main, and the
+// reason for there being none if the tests are to be skipped.
+var (
+	mainInstance *BriefPG
+	mainSkipErr  error
+)
+
+// Main starts a single instance to be shared by all of the tests in a
+// package, runs the tests, stops the instance, and exits.  Call it from
+// TestMain:
+//
+//	func TestMain(m *testing.M) {
+//		briefpg.Main(m, briefpg.OptSkipIfNotInstalled())
+//	}
+//
+// Tests retrieve the instance with Instance or InstanceT, and should create
+// their own databases in it (see CreateTestDB).  Options are applied as with
+// New(); OptWatchdog is always added, so that the server is stopped even if a
+// test panics or calls os.Exit, neither of which returns to Main.  (A shared
+// server, with OptShared, has no watchdog; if this process dies, the server
+// is stopped by the last remaining participant to leave, or by Reap.)  If
+// PostgreSQL can't be found and OptSkipIfNotInstalled is present, the tests
+// still run, but InstanceT skips them; any other failure to create or start
+// the instance is reported, and the process exits without running the tests.
+func Main(m *testing.M, options ...Option) {
+	os.Exit(runMain(m, options))
+}
+
+// runMain implements Main, returning the exit code.
+func runMain(m interface{ Run() int }, options []Option) (code int) {
+	ctx := context.Background()
+	options = append([]Option{OptWatchdog()}, options...)
+	bpg, err := New(options...)
+	if err != nil {
+		if wantSkip(options) && errors.Is(err, ErrPostgresNotFound) {
+			mainSkipErr = err
+			defer func() { mainSkipErr = nil }()
+			return m.Run()
+		}
+		fmt.Fprintf(os.Stderr, "briefpg: New failed: %v\n", err)
+		return 1
+	}
+	defer func() {
+		mainInstance = nil
+		if err := bpg.Fini(ctx); err != nil {
+			fmt.Fprintf(os.Stderr, "briefpg: Fini failed: %v\n", err)
+			if code == 0 {
+				code = 1
+			}
+		}
+	}()
+	if err := bpg.Start(ctx); err != nil {
+		fmt.Fprintf(os.Stderr, "briefpg: Start failed: %v\n", err)
+		return 1
+	}
+	mainInstance = bpg
+	return m.Run()
+}
+
+// Instance returns the instance started by Main, or nil if there is none.
+func Instance() *BriefPG {
+	return mainInstance
+}
+
+// InstanceT returns the instance started by Main.  If there is none, the test
+// t fails, unless Main was given OptSkipIfNotInstalled and PostgreSQL
+// couldn't be found, in which case it is skipped.
+func InstanceT(t testing.TB) *BriefPG {
+	t.Helper()
+	if mainInstance == nil {
+		if mainSkipErr != nil {
+			t.Skipf("briefpg: skipping test: %v", mainSkipErr)
+		}
+		t.Fatalf("briefpg: no instance; call briefpg.Main from TestMain")
+	}
+	return mainInstance
+}
+
+// ForEachVersion runs fn as a subtest of t once for each installed version of
+// Postgres (see InstalledVersions), passing it a started BriefPG made by NewT
+// with the given options.  The subtests are named after the versions, such as
+// "pg16.2".  If constraint is not "", versions which don't satisfy it (see
+// OptPostgresVersion for the syntax) are skipped.  If no installations are
+// found, t fails, unless OptSkipIfNotInstalled is present, in which case it is
+// skipped.
+func ForEachVersion(t *testing.T, constraint string, fn func(*testing.T, *BriefPG), options ...Option) {
+	t.Helper()
+	var vcs versionConstraints
+	if constraint != "" {
+		var err error
+		if vcs, err = parseConstraint(constraint); err != nil {
+			t.Fatalf("briefpg: %v", err)
+		}
+	}
+	insts, err := InstalledVersions()
+	if err != nil {
+		if wantSkip(options) {
+			t.Skipf("briefpg: skipping test: %v", err)
+		}
+		t.Fatalf("briefpg: InstalledVersions failed: %v", err)
+	}
+
+	for _, inst := range insts {
+		inst := inst
+		t.Run("pg"+inst.Version.String(), func(t *testing.T) {
+			if vcs != nil && !vcs.match(inst.Version) {
+				t.Skipf("briefpg: Postgres %s does not satisfy %q",
+					inst.Version, constraint)
+			}
+			opts := append(append([]Option{}, options...), OptPostgresPath(inst.Dir))
+			fn(t, NewT(t, opts...))
+		})
+	}
+}
+
+// wantSkip reports whether options includes OptSkipIfNotInstalled.
+func wantSkip(options []Option) bool {
+	for _, o := range options {
+		if _, ok := o.(skipOption); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// CreateTestDB creates a database for the exclusive use of the test t, and
+// returns its URI.  The database is named after t.Name(), adjusted to be a
+// legal Postgres identifier (and distinguished from those of other processes
+// if the server is shared), and is dropped when the test finishes.  If an
+// extension given with OptExtensions is not available, the test is skipped;
+// any other failure fails the test.
+func (bp *BriefPG) CreateTestDB(t testing.TB, createArgs string) string {
+	t.Helper()
+	ctx := context.Background()
+	testName := t.Name()
+	if bp.shared != nil {
+		// Other processes may run tests of the same name.
+		testName += fmt.Sprintf("#%d", os.Getpid())
+	}
+	dbName := testDBName(testName)
+	uri, err := bp.CreateDB(ctx, dbName, createArgs)
+	if errors.Is(err, ErrExtensionNotAvailable) {
+		t.Skipf("briefpg: skipping test: %v", err)
+	}
+	if err != nil {
+		t.Fatalf("briefpg: CreateTestDB failed: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := bp.dropTestDB(ctx, dbName); err != nil {
+			t.Errorf("briefpg: failed to drop test database: %v", err)
+		}
+	})
+	return uri
+}
+
+// AcquireTestDB acquires a database from the pool (see StartPool) for the
+// exclusive use of the test t, and returns its URI.  The database is released
+// when the test finishes.  Unlike CreateTestDB, this is safe to call from
+// parallel tests.  Any failure fails the test.
+func (bp *BriefPG) AcquireTestDB(t testing.TB) string {
+	t.Helper()
+	dbName, err := bp.AcquireDB(context.Background())
+	if err != nil {
+		t.Fatalf("briefpg: AcquireTestDB failed: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := bp.ReleaseDB(dbName); err != nil {
+			t.Errorf("briefpg: failed to release test database: %v", err)
+		}
+	})
+	return bp.DBUri(dbName)
+}
+
+// RequireExtensions skips the test t if any of the named extensions are not
+// available to the server.
+func (bp *BriefPG) RequireExtensions(t testing.TB, names ...string) {
+	t.Helper()
+	missing, err := bp.missingExtensions(context.Background(), names)
+	if err != nil {
+		t.Fatalf("briefpg: RequireExtensions failed: %v", err)
+	}
+	if len(missing) > 0 {
+		t.Skipf("briefpg: skipping test: %v: %s", ErrExtensionNotAvailable,
+			strings.Join(missing, ", "))
+	}
+}
+
+// dropTestDB drops a database made by CreateTestDB, disconnecting any
+// sessions the test left open.
+func (bp *BriefPG) dropTestDB(ctx context.Context, dbName string) error {
+	if bp.state < stateServerStarted {
+		return nil
+	}
+	return bp.DropDB(ctx, dbName, true)
+}
+
+// testDBName derives a database name from a test name.  Characters which
+// would need quoting are replaced, and a hash of the original name is
+// appended so that distinct tests (and subtests) get distinct databases.
+func testDBName(testName string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(testName) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '_' {
+			b.WriteRune(r)
+		} else {
+			b.WriteRune('_')
+		}
+	}
+	sum := sha256.Sum256([]byte(testName))
+	suffix := "_" + hex.EncodeToString(sum[:4])
+
+	name := b.String()
+	if len(name)+len(suffix) > maxIdentLen {
+		name = name[:maxIdentLen-len(suffix)]
+	}
+	return name + suffix
+}
@@ -0,0 +1,135 @@
+/*
+ * COPYRIGHT 2020 Brightgate Inc.  All rights reserved.
+ *
+ * This copyright notice is Copyright Management Information under 17 USC 1202
+ * and is included to protect this work and deter copyright infringement.
+ * Removal or alteration of this Copyright Management Information without the
+ * express written permission of Brightgate Inc is prohibited, and any
+ * such unauthorized removal or alteration will be a violation of federal law.
+ */
+
+package briefpg
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestNewT(t *testing.T) {
+	ctx := context.Background()
+	var bpg *BriefPG
+
+	t.Run("sub/test", func(t *testing.T) {
+		bpg = NewT(t, OptSkipIfNotInstalled())
+		uri := bpg.CreateTestDB(t, "")
+		if !strings.Contains(uri, testDBName(t.Name())) {
+			t.Fatalf("Unexpected URI: %s", uri)
+		}
+		if err := bpg.DumpDB(ctx, testDBName(t.Name()), &strings.Builder{}); err != nil {
+			t.Fatalf("DumpDB failed: %v", err)
+		}
+	})
+
+	// Cleanup should have stopped the server.
+	if bpg != nil && bpg.state != stateDefunct {
+		t.Fatalf("Expected instance to be defunct")
+	}
+}
+
+func TestForEachVersion(t *testing.T) {
+	var ran []string
+	ForEachVersion(t, "", func(t *testing.T, bpg *BriefPG) {
+		if !strings.HasSuffix(t.Name(), "/pg"+bpg.PgVer()) {
+			t.Fatalf("Unexpected subtest name %s for version %s", t.Name(), bpg.PgVer())
+		}
+		bpg.CreateTestDB(t, "")
+		ran = append(ran, bpg.PgVer())
+	}, OptSkipIfNotInstalled())
+
+	if len(ran) == 0 {
+		t.Fatalf("Expected at least one version to run")
+	}
+
+	// No version is older than 1, so all should be skipped.
+	ForEachVersion(t, "<1", func(t *testing.T, bpg *BriefPG) {
+		t.Fatalf("Expected version %s to be skipped", bpg.PgVer())
+	}, OptSkipIfNotInstalled())
+}
+
+func TestTestDBName(t *testing.T) {
+	name := testDBName("TestFoo/Sub-Test#01")
+	if !strings.HasPrefix(name, "testfoo_sub_test_01_") {
+		t.Fatalf("Unexpected name: %s", name)
+	}
+	if name == testDBName("TestFoo/Sub_Test#01") {
+		t.Fatalf("Expected distinct names for distinct tests")
+	}
+
+	long := testDBName(strings.Repeat("TestVeryLongName", 10))
+	if len(long) != maxIdentLen {
+		t.Fatalf("Expected name of length %d: %s", maxIdentLen, long)
+	}
+	if long != testDBName(strings.Repeat("TestVeryLongName", 10)) {
+		t.Fatalf("Expected stable name")
+	}
+}
+
+// runFunc adapts a function to the interface of testing.M used by runMain.
+type runFunc func() int
+
+func (f runFunc) Run() int {
+	return f()
+}
+
+func TestRunMain(t *testing.T) {
+	var bpg *BriefPG
+	code := runMain(runFunc(func() int {
+		t.Run("instance", func(t *testing.T) {
+			bpg = InstanceT(t)
+			if bpg.state != stateServerStarted || bpg.watchdog == nil {
+				t.Fatalf("Expected started instance with watchdog")
+			}
+			bpg.CreateTestDB(t, "")
+		})
+		return 3
+	}), []Option{OptLogFunc(t.Logf)})
+
+	if code != 3 {
+		t.Fatalf("Expected exit code 3; got %d", code)
+	}
+	if Instance() != nil {
+		t.Fatalf("Expected no instance after runMain")
+	}
+	if bpg == nil || bpg.state != stateDefunct {
+		t.Fatalf("Expected instance to be defunct")
+	}
+}
+
+func TestRunMainNotInstalled(t *testing.T) {
+	notFound := OptPostgresPath("/nonexistent")
+	ran := false
+	code := runMain(runFunc(func() int {
+		ran = true
+		if Instance() != nil {
+			t.Errorf("Expected no instance")
+		}
+		t.Run("skipped", func(t *testing.T) {
+			InstanceT(t)
+			t.Errorf("Expected InstanceT to skip")
+		})
+		return 0
+	}), []Option{notFound, OptSkipIfNotInstalled()})
+	if code != 0 || !ran {
+		t.Fatalf("Expected tests to run; got code %d", code)
+	}
+
+	ran = false
+	code = runMain(runFunc(func() int {
+		ran = true
+		return 0
+	}), []Option{notFound})
+	if code != 1 || ran {
+		t.Fatalf("Expected failure without running tests; got code %d", code)
+	}
+}
@@ -0,0 +1,242 @@
+/*
+ * COPYRIGHT 2020 Brightgate Inc.  All rights reserved.
+ *
+ * This copyright notice is Copyright Management Information under 17 USC 1202
+ * and is included to protect this work and deter copyright infringement.
+ * Removal or alteration of this Copyright Management Information without the
+ * express written permission of Brightgate Inc is prohibited, and any
+ * such unauthorized removal or alteration will be a violation of federal law.
+ */
+
+package briefpg
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// tlsValidity is the lifetime of generated certificates.
+const tlsValidity = 365 * 24 * time.Hour
+
+// TLSFiles holds the paths of the PEM files generated by OptTLS.  The client
+// certificate identifies the database superuser.
+type TLSFiles struct {
+	CACert     string // Certificate of the generated CA
+	ServerCert string // Server certificate, signed by the CA
+	ServerKey  string // Server private key
+	ClientCert string // Client certificate, signed by the CA
+	ClientKey  string // Client private key
+}
+
+// tlsState holds the generated CA, which is kept so that new certificates
+// can be issued by RotateTLS.
+type tlsState struct {
+	caCert *x509.Certificate
+	caKey  *ecdsa.PrivateKey
+	files  TLSFiles
+}
+
+func (bp *BriefPG) setTLS() error {
+	if bp.state >= stateInitialized {
+		return fmt.Errorf("TLS cannot be enabled after db has been initialized")
+	}
+	bp.useTLS = true
+	bp.listenTCP = true
+	return nil
+}
+
+// TLSFiles returns the paths of the certificate and key files generated by
+// OptTLS.  The files are generated by Start().
+func (bp *BriefPG) TLSFiles() TLSFiles {
+	if bp.tls == nil {
+		return TLSFiles{}
+	}
+	return bp.tls.files
+}
+
+// DBUriTLS returns a TCP connection URI for a named database, like DBUri, but
+// which also specifies the given sslmode (such as "require" or "verify-full"),
+// the generated CA certificate as the sslrootcert, and the generated client
+// certificate and key.  It requires OptTLS.  The URI names the host as
+// "localhost", which matches the server certificate, while connecting to the
+// loopback address.
+func (bp *BriefPG) DBUriTLS(dbName, sslMode string) string {
+	files := bp.TLSFiles()
+	params := url.Values{}
+	params.Set("hostaddr", tcpListenAddr)
+	params.Set("user", superUser)
+	if bp.password != "" {
+		params.Set("password", bp.password)
+	}
+	params.Set("sslmode", sslMode)
+	params.Set("sslrootcert", files.CACert)
+	params.Set("sslcert", files.ClientCert)
+	params.Set("sslkey", files.ClientKey)
+	return fmt.Sprintf("postgresql://localhost:%d/%s?%s", bp.port, dbName, params.Encode())
+}
+
+// RotateTLS issues new server and client certificates, replacing the files
+// reported by TLSFiles(), and reloads the server so that it uses them.  If
+// newCA is true, a new CA is generated as well, so that clients which trust
+// only the old CA will fail to verify the server.
+func (bp *BriefPG) RotateTLS(ctx context.Context, newCA bool) error {
+	if bp.tls == nil {
+		return fmt.Errorf("TLS not enabled; cannot rotate certificates")
+	}
+	if err := bp.generateTLS(newCA); err != nil {
+		return fmt.Errorf("RotateTLS failed: %w", err)
+	}
+	if bp.state == stateServerStarted {
+		return bp.Reload(ctx)
+	}
+	return nil
+}
+
+// tlsSettings returns the postgresql.conf settings needed for TLS, if it is
+// enabled.
+func (bp *BriefPG) tlsSettings() confMap {
+	settings := make(confMap)
+	if bp.tls == nil {
+		return settings
+	}
+	settings["ssl"] = "on"
+	settings["ssl_ca_file"] = bp.tls.files.CACert
+	settings["ssl_cert_file"] = bp.tls.files.ServerCert
+	settings["ssl_key_file"] = bp.tls.files.ServerKey
+	return settings
+}
+
+// generateTLS writes new server and client certificates and keys into the
+// "tls" subdirectory of the temporary directory, generating a new CA first if
+// newCA is set (or if there isn't one yet).
+func (bp *BriefPG) generateTLS(newCA bool) error {
+	dir := filepath.Join(bp.tmpDir, "tls")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("failed to make TLS dir: %w", err)
+	}
+	st := &tlsState{
+		files: TLSFiles{
+			CACert:     filepath.Join(dir, "ca.crt"),
+			ServerCert: filepath.Join(dir, "server.crt"),
+			ServerKey:  filepath.Join(dir, "server.key"),
+			ClientCert: filepath.Join(dir, "client.crt"),
+			ClientKey:  filepath.Join(dir, "client.key"),
+		},
+	}
+	bp.logf("briefpg: generating TLS certificates in %s\n", dir)
+
+	if newCA || bp.tls == nil {
+		tmpl := &x509.Certificate{
+			Subject:               pkix.Name{CommonName: "briefpg CA"},
+			IsCA:                  true,
+			BasicConstraintsValid: true,
+			KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		}
+		certDER, key, err := issueCert(tmpl, nil, nil)
+		if err != nil {
+			return err
+		}
+		if st.caCert, err = x509.ParseCertificate(certDER); err != nil {
+			return fmt.Errorf("failed to parse CA certificate: %w", err)
+		}
+		st.caKey = key
+		if err := writePEM(st.files.CACert, "CERTIFICATE", certDER, 0644); err != nil {
+			return err
+		}
+	} else {
+		st.caCert = bp.tls.caCert
+		st.caKey = bp.tls.caKey
+	}
+
+	server := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "localhost"},
+		DNSNames:    []string{"localhost"},
+		IPAddresses: []net.IP{net.ParseIP(tcpListenAddr), net.IPv6loopback},
+		KeyUsage:    x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	client := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: superUser},
+		KeyUsage:    x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	err := writeCert(server, st, st.files.ServerCert, st.files.ServerKey)
+	if err != nil {
+		return err
+	}
+	err = writeCert(client, st, st.files.ClientCert, st.files.ClientKey)
+	if err != nil {
+		return err
+	}
+	bp.tls = st
+	return nil
+}
+
+// issueCert generates a key pair and a certificate from tmpl, signed by
+// parent and parentKey; if parent is nil, the certificate is self-signed.
+func issueCert(tmpl, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) ([]byte, *ecdsa.PrivateKey, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate serial number: %w", err)
+	}
+	tmpl.SerialNumber = serial
+	tmpl.NotBefore = time.Now().Add(-time.Hour)
+	tmpl.NotAfter = time.Now().Add(tlsValidity)
+	if parent == nil {
+		parent = tmpl
+		parentKey = key
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create certificate: %w", err)
+	}
+	return certDER, key, nil
+}
+
+// writeCert issues a certificate from tmpl, signed by the CA in st, and
+// writes it and its key to the named files.
+func writeCert(tmpl *x509.Certificate, st *tlsState, certFile, keyFile string) error {
+	certDER, key, err := issueCert(tmpl, st.caCert, st.caKey)
+	if err != nil {
+		return err
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return fmt.Errorf("failed to marshal key: %w", err)
+	}
+	if err := writePEM(keyFile, "PRIVATE KEY", keyDER, 0600); err != nil {
+		return err
+	}
+	return writePEM(certFile, "CERTIFICATE", certDER, 0644)
+}
+
+// writePEM writes a single PEM block to the named file.  Postgres and libpq
+// both insist that private keys are not readable by others, so the file's
+// permissions are set even if it already exists.
+func writePEM(name, blockType string, der []byte, perm os.FileMode) error {
+	b := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	if err := ioutil.WriteFile(name, b, perm); err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	if err := os.Chmod(name, perm); err != nil {
+		return fmt.Errorf("failed to set mode of %s: %w", name, err)
+	}
+	return nil
+}
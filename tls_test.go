@@ -0,0 +1,137 @@
+/*
+ * COPYRIGHT 2020 Brightgate Inc.  All rights reserved.
+ *
+ * This copyright notice is Copyright Management Information under 17 USC 1202
+ * and is included to protect this work and deter copyright infringement.
+ * Removal or alteration of this Copyright Management Information without the
+ * express written permission of Brightgate Inc is prohibited, and any
+ * such unauthorized removal or alteration will be a violation of federal law.
+ */
+
+package briefpg
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func readCert(t *testing.T, name string) *x509.Certificate {
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatalf("ReadFile failed: %v", err)
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		t.Fatalf("No PEM data in %s", name)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatalf("ParseCertificate failed: %v", err)
+	}
+	return cert
+}
+
+func verifyCert(ca, cert *x509.Certificate, usage x509.ExtKeyUsage, name string) error {
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	_, err := cert.Verify(x509.VerifyOptions{
+		Roots:     roots,
+		DNSName:   name,
+		KeyUsages: []x509.ExtKeyUsage{usage},
+	})
+	return err
+}
+
+func TestGenerateTLS(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "test.")
+	if err != nil {
+		t.Fatalf("TempDir failed: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	bp := &BriefPG{tmpDir: tmpDir, logf: t.Logf}
+	if err := bp.generateTLS(true); err != nil {
+		t.Fatalf("generateTLS failed: %v", err)
+	}
+	files := bp.TLSFiles()
+	ca := readCert(t, files.CACert)
+	server := readCert(t, files.ServerCert)
+	client := readCert(t, files.ClientCert)
+	for _, name := range []string{"localhost", "127.0.0.1"} {
+		if err := verifyCert(ca, server, x509.ExtKeyUsageServerAuth, name); err != nil {
+			t.Fatalf("Server certificate failed to verify for %s: %v", name, err)
+		}
+	}
+	if err := verifyCert(ca, client, x509.ExtKeyUsageClientAuth, ""); err != nil {
+		t.Fatalf("Client certificate failed to verify: %v", err)
+	}
+	for _, key := range []string{files.ServerKey, files.ClientKey} {
+		fi, err := os.Stat(key)
+		if err != nil || fi.Mode().Perm() != 0600 {
+			t.Fatalf("Unexpected key file mode: %v %v", fi, err)
+		}
+	}
+
+	// Rotating without a new CA keeps the CA.
+	if err := bp.generateTLS(false); err != nil {
+		t.Fatalf("generateTLS failed: %v", err)
+	}
+	server2 := readCert(t, files.ServerCert)
+	if server2.SerialNumber.Cmp(server.SerialNumber) == 0 {
+		t.Fatalf("Expected a new server certificate")
+	}
+	if err := verifyCert(ca, server2, x509.ExtKeyUsageServerAuth, "localhost"); err != nil {
+		t.Fatalf("Rotated certificate failed to verify: %v", err)
+	}
+
+	// Rotating with a new CA should invalidate the old one.
+	if err := bp.generateTLS(true); err != nil {
+		t.Fatalf("generateTLS failed: %v", err)
+	}
+	server3 := readCert(t, files.ServerCert)
+	if err := verifyCert(ca, server3, x509.ExtKeyUsageServerAuth, "localhost"); err == nil {
+		t.Fatalf("Expected verification against old CA to fail")
+	}
+}
+
+func TestTLS(t *testing.T) {
+	ctx := context.Background()
+	bpg, err := New(OptLogFunc(t.Logf), OptTLS())
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer bpg.MustFini(ctx)
+
+	if err := bpg.RotateTLS(ctx, false); err == nil {
+		t.Fatalf("Expected RotateTLS to fail")
+	}
+	if err := bpg.Start(ctx); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	if bpg.Port() == 0 {
+		t.Fatalf("Expected OptTLS to enable TCP")
+	}
+
+	query := "SELECT ssl FROM pg_stat_ssl WHERE pid = pg_backend_pid()"
+	for _, mode := range []string{"require", "verify-ca", "verify-full"} {
+		cmd := exec.Command(bpg.pgCmds["psql"], "-X", "-A", "-t", "-c", query,
+			bpg.DBUriTLS("postgres", mode))
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("psql with sslmode=%s failed: %v: %s", mode, err, out)
+		}
+		if strings.TrimSpace(string(out)) != "t" {
+			t.Fatalf("Expected an SSL connection with sslmode=%s: %s", mode, out)
+		}
+	}
+
+	if err := bpg.RotateTLS(ctx, true); err != nil {
+		t.Fatalf("RotateTLS failed: %v", err)
+	}
+}
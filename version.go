@@ -0,0 +1,340 @@
+/*
+ * COPYRIGHT 2020 Brightgate Inc.  All rights reserved.
+ *
+ * This copyright notice is Copyright Management Information under 17 USC 1202
+ * and is included to protect this work and deter copyright infringement.
+ * Removal or alteration of this Copyright Management Information without the
+ * express written permission of Brightgate Inc is prohibited, and any
+ * such unauthorized removal or alteration will be a violation of federal law.
+ */
+
+package briefpg
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Version is a parsed Postgres version number.  Before Postgres 10, the major
+// version had two components (as in 9.6), so Minor is part of the major
+// version and Patch counts bug-fix releases; from 10 onwards, Minor counts
+// bug-fix releases and Patch is always zero.
+type Version struct {
+	Major int    // 16 in 16.2; 9 in 9.6.24
+	Minor int    // 2 in 16.2; 6 in 9.6.24
+	Patch int    // 24 in 9.6.24
+	Pre   string // Pre-release tag, such as "devel", "beta1" or "rc1"
+}
+
+// versionRE matches a version number, such as 16.2, 9.6.24 or 17beta1.
+var versionRE = regexp.MustCompile(`([0-9]+)(?:\.([0-9]+))?(?:\.([0-9]+))?([a-z]+[0-9]*)?`)
+
+// ParseVersion parses a Postgres version number.  It accepts either a bare
+// version, such as "16.2" or "17beta1", or the output of a Postgres command's
+// --version option, which may include vendor decoration, as in
+// "pg_ctl (PostgreSQL) 16.2 (Ubuntu 16.2-1.pgdg22.04+1)".
+func ParseVersion(s string) (Version, error) {
+	str := s
+	if i := strings.Index(str, "(PostgreSQL)"); i >= 0 {
+		str = str[i+len("(PostgreSQL)"):]
+	}
+	m := versionRE.FindStringSubmatch(str)
+	if m == nil {
+		return Version{}, fmt.Errorf("Unable to parse Postgres version %q", s)
+	}
+	var v Version
+	v.Major, _ = strconv.Atoi(m[1])
+	v.Minor, _ = strconv.Atoi(m[2])
+	v.Patch, _ = strconv.Atoi(m[3])
+	v.Pre = m[4]
+	return v, nil
+}
+
+// String returns the version in the form Postgres uses, such as "16.2",
+// "9.6.24" or "17beta1".  It does not include any vendor decoration, so it is
+// stable across builds of the same version.
+func (v Version) String() string {
+	if v.Major >= 10 {
+		if v.Pre != "" {
+			return fmt.Sprintf("%d%s", v.Major, v.Pre)
+		}
+		return fmt.Sprintf("%d.%d", v.Major, v.Minor)
+	}
+	if v.Pre != "" {
+		return fmt.Sprintf("%d.%d%s", v.Major, v.Minor, v.Pre)
+	}
+	return fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+}
+
+// Compare returns -1, 0 or 1 as v is older than, the same as, or newer than
+// o.  Pre-releases are older than the corresponding release.
+func (v Version) Compare(o Version) int {
+	if c := compareVersions(v.nums(), o.nums()); c != 0 {
+		return c
+	}
+	vr, vn := v.preRank()
+	or, on := o.preRank()
+	return compareVersions([]int{vr, vn}, []int{or, on})
+}
+
+// Less reports whether v is older than o.
+func (v Version) Less(o Version) bool {
+	return v.Compare(o) < 0
+}
+
+// AtLeast reports whether v is major.minor or newer, ignoring Patch and Pre;
+// for example, 16.2 is AtLeast(16, 0), and 9.6.24 is AtLeast(9, 6).
+func (v Version) AtLeast(major, minor int) bool {
+	return compareVersions([]int{v.Major, v.Minor}, []int{major, minor}) >= 0
+}
+
+// SupportsSCRAM reports whether the server supports SCRAM-SHA-256
+// authentication (Postgres 10 and later).
+func (v Version) SupportsSCRAM() bool {
+	return v.AtLeast(10, 0)
+}
+
+// SupportsDropForce reports whether the server supports DROP DATABASE ...
+// WITH (FORCE) (Postgres 13 and later).
+func (v Version) SupportsDropForce() bool {
+	return v.AtLeast(13, 0)
+}
+
+// SupportsMerge reports whether the server supports the MERGE statement
+// (Postgres 15 and later).
+func (v Version) SupportsMerge() bool {
+	return v.AtLeast(15, 0)
+}
+
+// nums returns the numeric components of the version, as used for
+// comparisons and by version constraints.
+func (v Version) nums() []int {
+	if v.Major >= 10 {
+		return []int{v.Major, v.Minor}
+	}
+	return []int{v.Major, v.Minor, v.Patch}
+}
+
+// preRank orders pre-release tags: development snapshots come first, then
+// alphas, betas and release candidates, and then the release itself.
+func (v Version) preRank() (int, int) {
+	if v.Pre == "" {
+		return 4, 0
+	}
+	i := strings.IndexAny(v.Pre, "0123456789")
+	tag, n := v.Pre, 0
+	if i >= 0 {
+		tag = v.Pre[:i]
+		n, _ = strconv.Atoi(v.Pre[i:])
+	}
+	switch tag {
+	case "alpha":
+		return 1, n
+	case "beta":
+		return 2, n
+	case "rc":
+		return 3, n
+	}
+	return 0, n
+}
+
+// Installation describes an installed copy of Postgres.
+type Installation struct {
+	Dir     string  // Directory containing the Postgres commands
+	Version Version // Version reported by pg_ctl
+}
+
+// InstalledVersions returns every installation of Postgres which can be
+// found in the user's $PATH or in the well-known postgres directories (see
+// New), newest version first.  Installations which are reachable through
+// more than one directory (for example, via symbolic links) are listed once.
+// The error wraps ErrPostgresNotFound if there are none.
+func InstalledVersions() ([]Installation, error) {
+	var insts []Installation
+	seen := make(map[string]bool)
+
+	allPaths := searchPath("")
+	for _, dir := range allPaths {
+		pgCmds := pgCmdsIn(dir)
+		if pgCmds == nil {
+			continue
+		}
+		realDir, err := filepath.EvalSymlinks(filepath.Dir(pgCmds["pg_ctl"]))
+		if err != nil {
+			realDir = dir
+		}
+		if seen[realDir] {
+			continue
+		}
+		seen[realDir] = true
+
+		ver, err := pgCtlVersion(pgCmds["pg_ctl"])
+		if err != nil {
+			continue
+		}
+		insts = append(insts, Installation{Dir: dir, Version: ver})
+	}
+
+	if len(insts) == 0 {
+		return nil, fmt.Errorf("%w; tried %s", ErrPostgresNotFound,
+			strings.Join(allPaths, ":"))
+	}
+	sort.SliceStable(insts, func(i, j int) bool {
+		return insts[j].Version.Less(insts[i].Version)
+	})
+	return insts, nil
+}
+
+// findVersion returns the newest installation which satisfies constraint.
+func findVersion(constraint string) (Installation, error) {
+	vcs, err := parseConstraint(constraint)
+	if err != nil {
+		return Installation{}, err
+	}
+	insts, err := InstalledVersions()
+	if err != nil {
+		return Installation{}, err
+	}
+	for _, inst := range insts {
+		if vcs.match(inst.Version) {
+			return inst, nil
+		}
+	}
+	var found []string
+	for _, inst := range insts {
+		found = append(found, inst.Version.String())
+	}
+	return Installation{}, fmt.Errorf("%w matching %q; found %s",
+		ErrPostgresNotFound, constraint, strings.Join(found, ", "))
+}
+
+func (bp *BriefPG) setPostgresVersion(constraint string) error {
+	if bp.state >= stateInitialized {
+		return fmt.Errorf("postgres version cannot be set after db has been initialized")
+	}
+	inst, err := findVersion(constraint)
+	if err != nil {
+		return err
+	}
+	return bp.setPostgresPath(inst.Dir)
+}
+
+// versionNums returns the numeric components of a version string, such as
+// [16 2] for "16.2".
+func versionNums(ver string) []int {
+	var nums []int
+	for _, part := range strings.Split(ver, ".") {
+		end := 0
+		for end < len(part) && part[end] >= '0' && part[end] <= '9' {
+			end++
+		}
+		n, err := strconv.Atoi(part[:end])
+		if err != nil {
+			break
+		}
+		nums = append(nums, n)
+		if end < len(part) {
+			break
+		}
+	}
+	return nums
+}
+
+// compareVersions compares two versions component by component, treating
+// missing components as zero.
+func compareVersions(a, b []int) int {
+	for i := 0; i < len(a) || i < len(b); i++ {
+		var x, y int
+		if i < len(a) {
+			x = a[i]
+		}
+		if i < len(b) {
+			y = b[i]
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// versionConstraint is a single comparison, such as ">=14".
+type versionConstraint struct {
+	op  string
+	ver []int
+}
+
+// versionConstraints is a list of comparisons, all of which must be
+// satisfied.
+type versionConstraints []versionConstraint
+
+// constraintOps lists the comparison operators, longest first so that
+// parsing is unambiguous.
+var constraintOps = []string{">=", "<=", "==", ">", "<", "="}
+
+// constraintVerRE matches the version in a constraint.
+var constraintVerRE = regexp.MustCompile(`^[0-9]+(\.[0-9]+)*$`)
+
+// parseConstraint parses a comma-separated list of comparisons such as
+// ">=13, <16".  A version with no operator (or with "=") matches any version
+// which begins with the same components, so "16" matches "16.2".  The other
+// operators compare only as many components as are given, so "<=15" matches
+// "15.6", and ">15" does not.
+func parseConstraint(constraint string) (versionConstraints, error) {
+	var vcs versionConstraints
+	for _, term := range strings.Split(constraint, ",") {
+		term = strings.TrimSpace(term)
+		vc := versionConstraint{op: "="}
+		for _, op := range constraintOps {
+			if strings.HasPrefix(term, op) {
+				vc.op = op
+				term = strings.TrimSpace(term[len(op):])
+				break
+			}
+		}
+		if vc.op == "==" {
+			vc.op = "="
+		}
+		if !constraintVerRE.MatchString(term) {
+			return nil, fmt.Errorf("Invalid version constraint %q", constraint)
+		}
+		vc.ver = versionNums(term)
+		vcs = append(vcs, vc)
+	}
+	return vcs, nil
+}
+
+// match reports whether ver satisfies all of the constraints.
+func (vcs versionConstraints) match(ver Version) bool {
+	for _, vc := range vcs {
+		v := ver.nums()
+		if len(v) > len(vc.ver) {
+			v = v[:len(vc.ver)]
+		}
+		c := compareVersions(v, vc.ver)
+		var ok bool
+		switch vc.op {
+		case "=":
+			ok = c == 0
+		case ">=":
+			ok = c >= 0
+		case "<=":
+			ok = c <= 0
+		case ">":
+			ok = c > 0
+		case "<":
+			ok = c < 0
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
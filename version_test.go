@@ -0,0 +1,159 @@
+/*
+ * COPYRIGHT 2020 Brightgate Inc.  All rights reserved.
+ *
+ * This copyright notice is Copyright Management Information under 17 USC 1202
+ * and is included to protect this work and deter copyright infringement.
+ * Removal or alteration of this Copyright Management Information without the
+ * express written permission of Brightgate Inc is prohibited, and any
+ * such unauthorized removal or alteration will be a violation of federal law.
+ */
+
+package briefpg
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+)
+
+func TestParseVersion(t *testing.T) {
+	tests := map[string]Version{
+		"16.2":    {Major: 16, Minor: 2},
+		"9.6.24":  {Major: 9, Minor: 6, Patch: 24},
+		"17beta1": {Major: 17, Pre: "beta1"},
+		"15rc2":   {Major: 15, Pre: "rc2"},
+		"pg_ctl (PostgreSQL) 16.2 (Ubuntu 16.2-1.pgdg22.04+1)": {Major: 16, Minor: 2},
+		"pg_ctl (PostgreSQL) 18devel":                          {Major: 18, Pre: "devel"},
+	}
+	for str, expected := range tests {
+		v, err := ParseVersion(str)
+		if err != nil {
+			t.Fatalf("ParseVersion(%q) failed: %v", str, err)
+		}
+		if v != expected {
+			t.Errorf("ParseVersion(%q) = %+v, expected %+v", str, v, expected)
+		}
+	}
+	if _, err := ParseVersion("bogus"); err == nil {
+		t.Errorf("Expected ParseVersion to fail")
+	}
+
+	// String should strip decoration, so that DbDir() names are stable.
+	strs := map[string]string{
+		"pg_ctl (PostgreSQL) 16.2 (Ubuntu 16.2-1.pgdg22.04+1)": "16.2",
+		"pg_ctl (PostgreSQL) 16.2":                             "16.2",
+		"9.6.24":                                               "9.6.24",
+		"9.6beta1":                                             "9.6beta1",
+		"17beta1":                                              "17beta1",
+	}
+	for str, expected := range strs {
+		v, _ := ParseVersion(str)
+		if v.String() != expected {
+			t.Errorf("ParseVersion(%q).String() = %q, expected %q", str, v, expected)
+		}
+	}
+}
+
+func TestVersionCompare(t *testing.T) {
+	// In ascending order
+	ordered := []string{"9.6.3", "9.6.24", "10.1", "16devel", "16alpha1",
+		"16beta1", "16beta2", "16rc1", "16.0", "16.2"}
+	for i := range ordered {
+		for j := range ordered {
+			a, _ := ParseVersion(ordered[i])
+			b, _ := ParseVersion(ordered[j])
+			c := a.Compare(b)
+			if (i < j && c != -1) || (i == j && c != 0) || (i > j && c != 1) {
+				t.Errorf("%s.Compare(%s) = %d", a, b, c)
+			}
+			if a.Less(b) != (i < j) {
+				t.Errorf("%s.Less(%s) = %v", a, b, a.Less(b))
+			}
+		}
+	}
+
+	v, _ := ParseVersion("13.4")
+	if !v.AtLeast(13, 0) || !v.AtLeast(9, 6) || v.AtLeast(13, 5) || v.AtLeast(14, 0) {
+		t.Errorf("Unexpected AtLeast results for %s", v)
+	}
+	if !v.SupportsSCRAM() || !v.SupportsDropForce() || v.SupportsMerge() {
+		t.Errorf("Unexpected feature support for %s", v)
+	}
+}
+
+func TestVersionConstraint(t *testing.T) {
+	tests := []struct {
+		constraint string
+		ver        string
+		match      bool
+	}{
+		{"16", "16.2", true},
+		{"16", "15.6", false},
+		{"16.2", "16.2", true},
+		{"16.2", "16.3", false},
+		{"==16", "16.0", true},
+		{">=14", "14.1", true},
+		{">=14", "13.9", false},
+		{">14", "14.9", false},
+		{">14", "15.0", true},
+		{"<=15", "15.6", true},
+		{"<16", "16.0", false},
+		{"<10", "9.6.24", true},
+		{">=13, <16", "15.1", true},
+		{">=13, <16", "16.1", false},
+		{">=13,<16", "12.1", false},
+	}
+	for _, tc := range tests {
+		vcs, err := parseConstraint(tc.constraint)
+		if err != nil {
+			t.Fatalf("parseConstraint(%q) failed: %v", tc.constraint, err)
+		}
+		v, err := ParseVersion(tc.ver)
+		if err != nil {
+			t.Fatalf("ParseVersion(%q) failed: %v", tc.ver, err)
+		}
+		if vcs.match(v) != tc.match {
+			t.Errorf("%q matching %q: expected %v", tc.constraint, tc.ver, tc.match)
+		}
+	}
+
+	for _, bad := range []string{"", ">=", "16beta", "~16", "16,", "1..2"} {
+		if _, err := parseConstraint(bad); err == nil {
+			t.Errorf("Expected parseConstraint(%q) to fail", bad)
+		}
+	}
+}
+
+func TestInstalledVersions(t *testing.T) {
+	insts, err := InstalledVersions()
+	if err != nil {
+		t.Fatalf("InstalledVersions failed: %v", err)
+	}
+	for i, inst := range insts {
+		t.Logf("found %s in %s", inst.Version, inst.Dir)
+		if pgCmdsIn(inst.Dir) == nil {
+			t.Fatalf("Expected Postgres in %s", inst.Dir)
+		}
+		if i > 0 && insts[i-1].Version.Less(inst.Version) {
+			t.Fatalf("Expected newest version first: %v", insts)
+		}
+	}
+
+	newest := insts[0]
+	bpg, err := New(OptPostgresVersion(strconv.Itoa(newest.Version.Major)))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if bpg.Version() != newest.Version {
+		t.Fatalf("Expected version %s, got %s", newest.Version, bpg.PgVer())
+	}
+
+	_, err = New(OptPostgresVersion("<1"))
+	if err == nil || !errors.Is(err, ErrPostgresNotFound) {
+		t.Fatalf("Expected New to fail with ErrPostgresNotFound: %v", err)
+	}
+	_, err = New(OptPostgresVersion("bogus"))
+	if err == nil {
+		t.Fatalf("Expected New to fail")
+	}
+}
@@ -0,0 +1,82 @@
+/*
+ * COPYRIGHT 2020 Brightgate Inc.  All rights reserved.
+ *
+ * This copyright notice is Copyright Management Information under 17 USC 1202
+ * and is included to protect this work and deter copyright infringement.
+ * Removal or alteration of this Copyright Management Information without the
+ * express written permission of Brightgate Inc is prohibited, and any
+ * such unauthorized removal or alteration will be a violation of federal law.
+ */
+
+package briefpg
+
+import (
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+)
+
+// watchdogShell runs watchdogScript.
+var watchdogShell = "/bin/sh"
+
+// watchdogScript is run by watchdogShell, with pg_ctl and the data directory as its
+// arguments.  Its standard input is a pipe from the process which started the
+// server.  When that process exits, for any reason, the pipe is closed, the
+// read completes, and the server is stopped.  Signals which might be sent to
+// the whole process group (such as an interrupt from the terminal) are
+// ignored, so that the watchdog outlives its parent.
+const watchdogScript = `trap '' HUP INT QUIT TERM
+read -r _
+exec "$0" -m immediate -w -D "$1" stop
+`
+
+// watchdog is a supervisor process which stops the server if we die.
+type watchdog struct {
+	cmd  *exec.Cmd
+	pipe io.WriteCloser
+}
+
+func (bp *BriefPG) setWatchdog() error {
+	if bp.state >= stateServerStarted {
+		return fmt.Errorf("watchdog cannot be enabled after server has started")
+	}
+	bp.useWatchdog = true
+	return nil
+}
+
+// startWatchdog starts the watchdog process, if it's enabled and not already
+// running.  The watchdog is deliberately not tied to any context, as it must
+// outlive this process.
+func (bp *BriefPG) startWatchdog() error {
+	if !bp.useWatchdog || bp.watchdog != nil {
+		return nil
+	}
+	cmd := exec.Command(watchdogShell, "-c", watchdogScript, bp.pgCmds["pg_ctl"], bp.DbDir())
+	pipe, err := cmd.StdinPipe()
+	if err != nil {
+		return fmt.Errorf("Failed to make watchdog pipe: %w", err)
+	}
+	bp.logf("briefpg: starting watchdog: %s\n", strings.Join(cmd.Args[3:], " "))
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("Failed to start watchdog: %w", err)
+	}
+	bp.watchdog = &watchdog{
+		cmd:  cmd,
+		pipe: pipe,
+	}
+	return nil
+}
+
+// stopWatchdog kills the watchdog process, if any.  It should be called once
+// the server has been stopped for good.
+func (bp *BriefPG) stopWatchdog() {
+	if bp.watchdog == nil {
+		return
+	}
+	bp.logf("briefpg: stopping watchdog\n")
+	bp.watchdog.cmd.Process.Kill()
+	bp.watchdog.cmd.Wait()
+	bp.watchdog.pipe.Close()
+	bp.watchdog = nil
+}
@@ -0,0 +1,108 @@
+/*
+ * COPYRIGHT 2020 Brightgate Inc.  All rights reserved.
+ *
+ * This copyright notice is Copyright Management Information under 17 USC 1202
+ * and is included to protect this work and deter copyright infringement.
+ * Removal or alteration of this Copyright Management Information without the
+ * express written permission of Brightgate Inc is prohibited, and any
+ * such unauthorized removal or alteration will be a violation of federal law.
+ */
+
+package briefpg
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+// TestWatchdogHelper is run in a subprocess by TestWatchdog.  It starts a
+// server in the directory given by the environment, and exits without
+// cleaning up.
+func TestWatchdogHelper(t *testing.T) {
+	tmpDir := os.Getenv("BRIEFPG_WATCHDOG_DIR")
+	if tmpDir == "" {
+		t.Skip("only run by TestWatchdog")
+	}
+	bpg, err := New(OptTmpDir(tmpDir), OptWatchdog())
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if err := bpg.Start(context.Background()); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	os.Exit(0)
+}
+
+func TestWatchdog(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "test.")
+	if err != nil {
+		t.Fatalf("TempDir failed: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestWatchdogHelper$")
+	cmd.Env = append(os.Environ(), "BRIEFPG_WATCHDOG_DIR="+tmpDir)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("helper failed: %v: %s", err, out)
+	}
+
+	pms := postmasters(tmpDir)
+	if len(pms) != 1 {
+		t.Fatalf("Expected a server to have been started: %v", pms)
+	}
+	deadline := time.Now().Add(30 * time.Second)
+	for processAlive(pms[0]) {
+		if time.Now().After(deadline) {
+			stopPostmaster(context.Background(), pms[0])
+			t.Fatalf("Expected watchdog to stop server %d", pms[0])
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func TestWatchdogFini(t *testing.T) {
+	ctx := context.Background()
+	bpg, err := New(OptLogFunc(t.Logf), OptWatchdog())
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if err := bpg.Start(ctx); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	if bpg.watchdog == nil {
+		t.Fatalf("Expected watchdog to be running")
+	}
+	bpg.MustFini(ctx)
+	if bpg.watchdog != nil {
+		t.Fatalf("Expected watchdog to be stopped")
+	}
+	if err := bpg.SetOption(OptWatchdog()); err == nil {
+		t.Fatalf("Expected SetOption to fail")
+	}
+}
+
+func TestWatchdogStartFailure(t *testing.T) {
+	ctx := context.Background()
+	defer func(shell string) { watchdogShell = shell }(watchdogShell)
+	watchdogShell = "/nonexistent/sh"
+
+	bpg, err := New(OptLogFunc(t.Logf), OptWatchdog())
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer bpg.MustFini(ctx)
+	if err := bpg.Start(ctx); err == nil {
+		t.Fatalf("Expected Start to fail")
+	}
+	if bpg.state != stateInitialized {
+		t.Fatalf("Expected server not to be started; state %v", bpg.state)
+	}
+	if sharedServerRunning(bpg.DbDir()) {
+		t.Fatalf("Expected server to be stopped")
+	}
+}